@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/marktlinn/Gorcherstrator/manager"
+)
+
+// runCommand runs one of the command line sub-commands against the Manager at the given address:
+//
+//	backup <file>                - export the Manager's full state to file
+//	restore <file>               - import a file produced by backup into a fresh Manager
+//	exec [-t] <taskID> <cmd...>  - run a command in a running Task, see execCommand
+func runCommand(manager string, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: gorcherstrator backup|restore <file> | exec [-t] <taskID> <cmd...>")
+	}
+
+	switch args[0] {
+	case "backup", "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gorcherstrator %s <file>", args[0])
+		}
+		if args[0] == "backup" {
+			return backup(manager, args[1])
+		}
+		return restore(manager, args[1])
+	case "exec":
+		return execCommand(manager, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// backup downloads the Manager's state archive into the named file. The archive is checked to be a complete
+// backup before it replaces the file, so a failed download never leaves an empty or broken archive behind.
+func backup(addr, file string) error {
+	res, err := http.Get(fmt.Sprintf("http://%s/backup", addr))
+	if err != nil {
+		return fmt.Errorf("failed to connect to manager %s: %w", addr, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("backup failed with StatusCode %d", res.StatusCode)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write backup to %s: %w", file, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := manager.ReadBackup(f); err != nil {
+		f.Close()
+		return fmt.Errorf("manager %s returned an invalid backup: %w", addr, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return err
+	}
+
+	fmt.Printf("Manager state from %s backed up to %s\n", addr, file)
+	return nil
+}
+
+// restore uploads the state archive in the named file to the Manager.
+func restore(manager, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := http.Post(fmt.Sprintf("http://%s/restore", manager), "application/gzip", f)
+	if err != nil {
+		return fmt.Errorf("failed to connect to manager %s: %w", manager, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("restore failed with StatusCode %d: %s", res.StatusCode, msg)
+	}
+
+	fmt.Printf("Manager %s restored from %s\n", manager, file)
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/marktlinn/Gorcherstrator/worker"
+)
+
+// execCommand runs a command in a running Task through the Manager, with the token in EXEC_TOKEN, wiring
+// it to this process's stdin, stdout and stderr. With -t the command gets a TTY. The process exits with
+// the command's exit code.
+func execCommand(manager string, args []string) error {
+	req := worker.ExecRequest{}
+	if args[0] == "-t" {
+		req.Tty = true
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return errors.New("usage: gorcherstrator exec [-t] <taskID> <cmd...>")
+	}
+	req.Cmd = args[1:]
+
+	code, err := worker.Exec(manager, args[0], os.Getenv("EXEC_TOKEN"), req, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return fmt.Errorf("exec into task %s failed: %w", args[0], err)
+	}
+	os.Exit(code)
+	return nil
+}
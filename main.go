@@ -2,12 +2,17 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/marktlinn/Gorcherstrator/manager"
+	"github.com/marktlinn/Gorcherstrator/node"
+	"github.com/marktlinn/Gorcherstrator/raft"
 	"github.com/marktlinn/Gorcherstrator/scheduler"
 	"github.com/marktlinn/Gorcherstrator/store"
+	"github.com/marktlinn/Gorcherstrator/task"
 	"github.com/marktlinn/Gorcherstrator/worker"
 )
 
@@ -18,44 +23,189 @@ func main() {
 	mHost := os.Getenv("MANAGER_HOST")
 	mPort, _ := strconv.Atoi(os.Getenv("MANAGER_PORT"))
 
+	if len(os.Args) > 1 {
+		if err := runCommand(fmt.Sprintf("%s:%d", mHost, mPort), os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	dbType := os.Getenv("STORE_TYPE")
+	if dbType == "" {
+		dbType = store.MEMORY
+	}
+
+	// ROLE selects which half of the cluster this process runs: "worker", "manager" or, by default, both.
+	role := os.Getenv("ROLE")
+
+	workers := []string{
+		fmt.Sprintf("%s:%d", wHost, wPort),
+		fmt.Sprintf("%s:%d", wHost, wPort+1),
+		fmt.Sprintf("%s:%d", wHost, wPort+2),
+	}
+
+	// WORKER_RUNTIME selects how Workers run Tasks; see task.NewRuntime.
+	runtimeType := os.Getenv("WORKER_RUNTIME")
+	if runtimeType == "" {
+		runtimeType = task.DOCKER
+	}
+
+	// WORKER_ALLOWED_HOST_PATHS lists, comma separated, the host directories Tasks may bind mount.
+	var allowedHostPaths []string
+	if paths := os.Getenv("WORKER_ALLOWED_HOST_PATHS"); paths != "" {
+		allowedHostPaths = strings.Split(paths, ",")
+	}
+
+	// WORKER_REGISTRY_AUTH names a JSON file of the credentials Workers pull images with; see
+	// task.LoadRegistryAuth.
+	var registryAuth map[string]task.RegistryAuth
+	if path := os.Getenv("WORKER_REGISTRY_AUTH"); path != "" {
+		auth, err := task.LoadRegistryAuth(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		registryAuth = auth
+	}
+
+	// WORKER_ORPHAN_POLICY decides what Workers do on starting with containers which belong to none of their
+	// Tasks: "remove" them or "keep" them. Workers with a persistent store remove them by default, and
+	// others keep them; see worker.OrphanRemove.
+	orphanPolicy := os.Getenv("WORKER_ORPHAN_POLICY")
+	if orphanPolicy != "" && orphanPolicy != worker.OrphanRemove && orphanPolicy != worker.OrphanKeep {
+		log.Fatalf("unknown orphan policy %q", orphanPolicy)
+	}
+
+	// WORKER_TASK_RETENTION, written as "maxAge,maxCount", bounds how long, and how many, finished Tasks each
+	// Worker keeps; see store.ParseRetentionPolicy.
+	var workerRetention *store.RetentionPolicy
+	if r := os.Getenv("WORKER_TASK_RETENTION"); r != "" {
+		p, err := store.ParseRetentionPolicy(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		workerRetention = &p
+	}
+
+	// EXEC_TOKEN is the bearer token authorizing exec into Tasks, through the Manager or a Worker. Exec is
+	// disabled without it.
+	execToken := os.Getenv("EXEC_TOKEN")
+
+	if role != "manager" {
+		startWorkers(wHost, wPort, dbType, runtimeType, execToken, func(w *worker.Worker) {
+			w.AllowedHostPaths = allowedHostPaths
+			w.RegistryAuth = registryAuth
+			if orphanPolicy != "" {
+				w.OrphanPolicy = orphanPolicy
+			}
+			if workerRetention != nil {
+				w.TaskRetention = *workerRetention
+			}
+		})
+	}
+	if role == "worker" {
+		select {}
+	}
+
+	// MANAGER_PEERS lists the address of every Manager replica, including this one. When set, this
+	// Manager joins a highly available cluster and its state is replicated through Raft. MANAGER_RAFT_SECRET
+	// must then be set to the same value on every replica, authenticating the replicas to each other.
+	var m *manager.Manager
+	if peers := os.Getenv("MANAGER_PEERS"); peers != "" {
+		dataDir := os.Getenv("MANAGER_DATA_DIR")
+		if dataDir == "" {
+			dataDir = fmt.Sprintf("manager_raft_%d", mPort)
+		}
+		cfg := raft.Config{
+			ID:      fmt.Sprintf("%s:%d", mHost, mPort),
+			Peers:   strings.Split(peers, ","),
+			DataDir: dataDir,
+			Secret:  os.Getenv("MANAGER_RAFT_SECRET"),
+		}
+		m = manager.NewReplicated(workers, scheduler.EPVM, cfg)
+	} else {
+		m = manager.New(workers, scheduler.EPVM, dbType)
+	}
+	if m == nil {
+		log.Fatal("failed to create manager")
+	}
+
+	// WORKER_PORT_RANGE, written as "min-max", is the range dynamic host ports are allocated from on each
+	// Worker; see node.DefaultPortRange.
+	if r := os.Getenv("WORKER_PORT_RANGE"); r != "" {
+		portRange, err := node.ParsePortRange(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, n := range m.WorkerNodes {
+			n.PortRange = portRange
+		}
+	}
+
+	// MANAGER_TASK_RETENTION and MANAGER_EVENT_RETENTION, written like WORKER_TASK_RETENTION, bound how long,
+	// and how many, finished Tasks and TaskEvents the Manager keeps.
+	for env, p := range map[string]*store.RetentionPolicy{
+		"MANAGER_TASK_RETENTION":  &m.TaskRetention,
+		"MANAGER_EVENT_RETENTION": &m.EventRetention,
+	} {
+		if r := os.Getenv(env); r != "" {
+			policy, err := store.ParseRetentionPolicy(r)
+			if err != nil {
+				log.Fatal(err)
+			}
+			*p = policy
+		}
+	}
+	managerApi := manager.Api{Address: mHost, Port: mPort, Manager: m, ExecToken: execToken}
+
+	go m.ProcessTasks()
+	go m.UpdateTasks()
+	go m.RunHealthChecks()
+	go m.SnapshotState()
+	go m.CollectGarbage()
+
+	managerApi.Start()
+}
+
+// startWorkers starts three Workers, and their Apis, on consecutive ports from wPort, each configured by
+// configure and then adopting the containers left from its previous run before it starts. The Apis authorize
+// exec with execToken.
+func startWorkers(wHost string, wPort int, dbType, runtimeType, execToken string, configure func(*worker.Worker)) {
 	fmt.Println("Starting Worker")
 
-	w1 := worker.New("ex_worker1", store.MEMORY)
-	workerApi := worker.Api{Address: wHost, Port: wPort, Worker: w1}
+	w1 := worker.New("ex_worker1", dbType, runtimeType)
+	workerApi := worker.Api{Address: wHost, Port: wPort, Worker: w1, ExecToken: execToken}
+
+	w2 := worker.New("ex_worker2", dbType, runtimeType)
+	workerApi2 := worker.Api{Address: wHost, Port: wPort + 1, Worker: w2, ExecToken: execToken}
 
-	w2 := worker.New("ex_worker2", store.MEMORY)
-	workerApi2 := worker.Api{Address: wHost, Port: wPort + 1, Worker: w2}
+	w3 := worker.New("ex_worker3", dbType, runtimeType)
+	workerApi3 := worker.Api{Address: wHost, Port: wPort + 2, Worker: w3, ExecToken: execToken}
 
-	w3 := worker.New("ex_worker3", store.MEMORY)
-	workerApi3 := worker.Api{Address: wHost, Port: wPort + 2, Worker: w3}
+	for _, w := range []*worker.Worker{w1, w2, w3} {
+		configure(w)
+		report, err := w.AdoptContainers()
+		if err != nil {
+			log.Printf("failed to adopt containers: %s\n", err)
+			continue
+		}
+		log.Printf("Worker %s %s\n", w.Name, report)
+	}
 
 	go w1.RunTasks()
 	go w1.CollectStats()
 	go w1.UpdateTasks()
+	go w1.CollectGarbage()
 	go workerApi.Start()
 
 	go w2.RunTasks()
 	go w2.CollectStats()
 	go w2.UpdateTasks()
+	go w2.CollectGarbage()
 	go workerApi2.Start()
 
 	go w3.RunTasks()
 	go w3.CollectStats()
 	go w3.UpdateTasks()
+	go w3.CollectGarbage()
 	go workerApi3.Start()
-
-	workers := []string{
-		fmt.Sprintf("%s:%d", wHost, wPort),
-		fmt.Sprintf("%s:%d", wHost, wPort+1),
-		fmt.Sprintf("%s:%d", wHost, wPort+2),
-	}
-
-	m := manager.New(workers, scheduler.EPVM, store.MEMORY)
-	managerApi := manager.Api{Address: mHost, Port: mPort, Manager: m}
-
-	go m.ProcessTasks()
-	go m.UpdateTasks()
-	go m.RunHealthChecks()
-
-	managerApi.Start()
 }
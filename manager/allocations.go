@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"log"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/node"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// nodeFor returns the Node of the named Worker, or nil if the Worker is unknown.
+func (m *Manager) nodeFor(worker string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == worker {
+			return n
+		}
+	}
+	return nil
+}
+
+// allocate reserves the host ports and disk the Task needs on the Node, returning the host port allocated
+// for each of the Task's PortBindings.
+func allocate(n *node.Node, t task.Task) (map[nat.Port]string, error) {
+	reqs, err := t.PortRequests()
+	if err != nil {
+		return nil, err
+	}
+	ports, err := n.AllocatePorts(t.ID.String(), reqs)
+	if err != nil {
+		return nil, err
+	}
+	n.AllocateDisk(t.ID.String(), t.Disk)
+	return ports, nil
+}
+
+// portBindings converts the host ports allocated to a Task back into its PortBindings.
+func portBindings(ports map[nat.Port]string) map[string]string {
+	if len(ports) == 0 {
+		return nil
+	}
+	bindings := make(map[string]string, len(ports))
+	for p, hostPort := range ports {
+		bindings[string(p)] = hostPort
+	}
+	return bindings
+}
+
+// release frees the host ports and disk allocated to the Task on every Node.
+func (m *Manager) release(taskID uuid.UUID) {
+	for _, n := range m.WorkerNodes {
+		n.Release(taskID.String())
+	}
+}
+
+// rebuildAllocations recomputes what is allocated on every Node from the Tasks in the TaskDB, so that a
+// Manager which has just started, or has just become the leader, does not hand out host ports already in
+// use or lose track of the disk its Tasks need.
+func (m *Manager) rebuildAllocations() {
+	for _, n := range m.WorkerNodes {
+		n.Reset()
+	}
+
+	tasks, err := m.TaskDB.List()
+	if err != nil {
+		log.Printf("failed to list tasks to rebuild node allocations: %s\n", err)
+		return
+	}
+	for _, t := range tasks {
+		if t.Worker == "" || task.IsTerminal(t.State) {
+			continue
+		}
+		n := m.nodeFor(t.Worker)
+		if n == nil {
+			continue
+		}
+		if _, err := allocate(n, *t); err != nil {
+			log.Printf("failed to restore allocations of task %s: %s\n", t.ID, err)
+		}
+	}
+}
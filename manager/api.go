@@ -1,11 +1,17 @@
 package manager
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 )
 
+// ForwardedByHeader is set on requests a follower Manager forwards to its leader.
+const ForwardedByHeader = "X-Forwarded-By-Manager"
+
 type ApiErrorResponse struct {
 	HTTPStatusCode int
 	Message        string
@@ -17,14 +23,56 @@ type Api struct {
 	Router  *http.ServeMux
 	Manager *Manager
 	Port    int
+	// ExecToken is the bearer token authorizing requests to exec into Tasks. Exec is disabled when it is empty.
+	ExecToken string
 }
 
 // initRouter initialises the Api Router setting up the necessary routes in the process.
 func (a *Api) initRouter() {
 	a.Router = http.NewServeMux()
-	a.Router.HandleFunc("POST /tasks", a.StartTaskHandler)
+	a.Router.HandleFunc("POST /tasks", a.forwardToLeader(a.StartTaskHandler))
 	a.Router.HandleFunc("GET /tasks", a.GetTaskHandler)
-	a.Router.HandleFunc("DELETE /tasks/{taskID}", a.StopTaskHandler)
+	a.Router.HandleFunc("DELETE /tasks/{taskID}", a.forwardToLeader(a.StopTaskHandler))
+	a.Router.HandleFunc("GET /tasks/{taskID}/events", a.GetTaskEventsHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/logs", a.GetTaskLogsHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/exec", a.ExecTaskHandler)
+	a.Router.HandleFunc("GET /backup", a.forwardToLeader(a.BackupHandler))
+	a.Router.HandleFunc("POST /restore", a.forwardToLeader(a.RestoreHandler))
+	a.Router.HandleFunc("GET /cluster", a.GetClusterHandler)
+	if h := a.Manager.RaftHandler(); h != nil {
+		a.Router.Handle("/raft/", h)
+	}
+}
+
+// forwardToLeader serves requests with the handler when the Manager is the leader of its cluster, and
+// otherwise proxies them to the leader. Requests already forwarded by another Manager are never forwarded again.
+func (a *Api) forwardToLeader(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Manager.IsLeader() {
+			handler(w, r)
+			return
+		}
+
+		leader := a.Manager.Leader()
+		if leader == "" || r.Header.Get(ForwardedByHeader) != "" {
+			errMsg := "no leader is available to handle the request\n"
+			log.Println(errMsg)
+			w.WriteHeader(503)
+			errRes := ApiErrorResponse{
+				Message:        errMsg,
+				HTTPStatusCode: 503,
+			}
+			if err := json.NewEncoder(w).Encode(errRes); err != nil {
+				log.Printf("error encoding json response: %s\n", err)
+			}
+			return
+		}
+
+		log.Printf("forwarding %s %s to leader %s\n", r.Method, r.URL.Path, leader)
+		r.Header.Set(ForwardedByHeader, fmt.Sprintf("%s:%d", a.Address, a.Port))
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: leader})
+		proxy.ServeHTTP(w, r)
+	}
 }
 
 // Starts the server and invokes the initRouter ensuring the routes are established.
@@ -0,0 +1,230 @@
+package manager
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// backupVersion is the version of the Backup format written by Export.
+const backupVersion = 1
+
+// ErrNotFresh is returned by Import when the Manager already holds Tasks, TaskEvents or queued work.
+var ErrNotFresh = errors.New("manager already holds state")
+
+// Backup is a portable archive of a Manager's full state. It holds plain values rather than
+// anything specific to a store backend, so it can be restored into a Manager using any backend.
+type Backup struct {
+	Version       int
+	CreatedAt     time.Time
+	Tasks         []*task.Task
+	Events        []*task.TaskEvent
+	Pending       []task.TaskEvent
+	WorkerTaskMap map[string][]uuid.UUID
+	TaskWorkerMap map[uuid.UUID]string
+	LastWorker    int
+}
+
+// Export writes a gzip compressed JSON Backup of the Manager's TaskDB, EventDB, task/worker maps
+// and Pending queue to w.
+func (m *Manager) Export(w io.Writer) error {
+	b, err := m.backup()
+	if err != nil {
+		return err
+	}
+	return writeBackup(w, b)
+}
+
+// writeBackup writes the Backup to w as gzip compressed JSON.
+func writeBackup(w io.Writer, b Backup) error {
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(b); err != nil {
+		return fmt.Errorf("failed to encode backup: %w", err)
+	}
+	return gz.Close()
+}
+
+// ReadBackup reads a Backup written by Export from r, checking it is complete and of a supported version.
+func ReadBackup(r io.Reader) (Backup, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer gz.Close()
+
+	var b Backup
+	if err := json.NewDecoder(gz).Decode(&b); err != nil {
+		return Backup{}, fmt.Errorf("failed to decode backup: %w", err)
+	}
+	if b.Version != backupVersion {
+		return Backup{}, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+	return b, nil
+}
+
+// backup captures the Manager's full state as a Backup.
+func (m *Manager) backup() (Backup, error) {
+	m.mu.Lock()
+	state := m.snapshot()
+	m.mu.Unlock()
+
+	tasks, err := m.TaskDB.List()
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	events, err := m.EventDB.List()
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	return Backup{
+		Version:       backupVersion,
+		CreatedAt:     time.Now().UTC(),
+		Tasks:         tasks,
+		Events:        events,
+		Pending:       state.Pending,
+		WorkerTaskMap: state.WorkerTaskMap,
+		TaskWorkerMap: state.TaskWorkerMap,
+		LastWorker:    state.LastWorker,
+	}, nil
+}
+
+// Import reads a Backup written by Export from r and loads it into the Manager.
+// The Manager must be fresh: an empty TaskDB, EventDB and Pending queue. The whole Backup is checked before
+// anything is loaded, and what has been loaded is removed again if loading the rest fails, so the Manager is
+// either fully restored or left fresh. Once restored, the host ports and disk of the restored Tasks are
+// allocated on their Workers' Nodes again.
+func (m *Manager) Import(r io.Reader) error {
+	b, err := ReadBackup(r)
+	if err != nil {
+		return err
+	}
+	state, err := b.state()
+	if err != nil {
+		return err
+	}
+
+	if err := m.checkFresh(); err != nil {
+		return err
+	}
+
+	var tasks, events []string
+	undo := func() {
+		for _, key := range tasks {
+			m.TaskDB.Delete(key)
+		}
+		for _, key := range events {
+			m.EventDB.Delete(key)
+		}
+	}
+	for _, t := range b.Tasks {
+		if err := m.TaskDB.Put(t.ID.String(), t); err != nil {
+			undo()
+			return fmt.Errorf("failed to restore task %s: %w", t.ID, err)
+		}
+		tasks = append(tasks, t.ID.String())
+	}
+	for _, e := range b.Events {
+		if err := m.EventDB.Put(e.ID.String(), e); err != nil {
+			undo()
+			return fmt.Errorf("failed to restore event %s: %w", e.ID, err)
+		}
+		events = append(events, e.ID.String())
+	}
+
+	// The queue and task/worker maps are restored in a single stateEntry, so they are restored whole or not at all.
+	if _, err := m.commit(stateEntry{Op: opRestore, State: &state}); err != nil {
+		undo()
+		return fmt.Errorf("failed to restore manager state: %w", err)
+	}
+
+	m.mu.Lock()
+	m.rebuildAllocations()
+	m.mu.Unlock()
+	return nil
+}
+
+// state checks the Backup is consistent, returning the queue and task/worker maps it holds.
+func (b Backup) state() (managerState, error) {
+	tasks := make(map[uuid.UUID]bool, len(b.Tasks))
+	for _, t := range b.Tasks {
+		if t == nil || t.ID == uuid.Nil {
+			return managerState{}, errors.New("backup holds a task without an ID")
+		}
+		if tasks[t.ID] {
+			return managerState{}, fmt.Errorf("backup holds task %s twice", t.ID)
+		}
+		tasks[t.ID] = true
+	}
+	events := make(map[uuid.UUID]bool, len(b.Events))
+	for _, e := range b.Events {
+		if e == nil || e.ID == uuid.Nil {
+			return managerState{}, errors.New("backup holds a task event without an ID")
+		}
+		if events[e.ID] {
+			return managerState{}, fmt.Errorf("backup holds task event %s twice", e.ID)
+		}
+		events[e.ID] = true
+	}
+
+	state := managerState{
+		Pending:       b.Pending,
+		WorkerTaskMap: make(map[string][]uuid.UUID),
+		TaskWorkerMap: make(map[uuid.UUID]string),
+		LastWorker:    b.LastWorker,
+	}
+	assign := func(id uuid.UUID, w string) error {
+		if !tasks[id] {
+			return fmt.Errorf("backup assigns unknown task %s to worker %s", id, w)
+		}
+		if assigned, ok := state.TaskWorkerMap[id]; ok {
+			if assigned != w {
+				return fmt.Errorf("backup assigns task %s to both worker %s and %s", id, assigned, w)
+			}
+			return nil
+		}
+		state.TaskWorkerMap[id] = w
+		state.WorkerTaskMap[w] = append(state.WorkerTaskMap[w], id)
+		return nil
+	}
+	for w, ids := range b.WorkerTaskMap {
+		for _, id := range ids {
+			if err := assign(id, w); err != nil {
+				return managerState{}, err
+			}
+		}
+	}
+	for id, w := range b.TaskWorkerMap {
+		if err := assign(id, w); err != nil {
+			return managerState{}, err
+		}
+	}
+	return state, nil
+}
+
+// checkFresh returns ErrNotFresh unless the Manager holds no Tasks, TaskEvents or queued work.
+func (m *Manager) checkFresh() error {
+	tasks, err := m.TaskDB.Count()
+	if err != nil {
+		return err
+	}
+	events, err := m.EventDB.Count()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	pending := m.Pending.Len()
+	m.mu.Unlock()
+
+	if tasks > 0 || events > 0 || pending > 0 {
+		return fmt.Errorf("%w: %d tasks, %d events and %d pending", ErrNotFresh, tasks, events, pending)
+	}
+	return nil
+}
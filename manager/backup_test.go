@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+func TestImportAllocatesRestoredTasks(t *testing.T) {
+	const worker = "127.0.0.1:1"
+	running := &task.Task{
+		ID:           uuid.New(),
+		Name:         "web",
+		Image:        "nginx",
+		State:        task.Running,
+		Worker:       worker,
+		PortBindings: map[string]string{"80/tcp": "30080"},
+		Disk:         1 << 30,
+	}
+	var buf bytes.Buffer
+	if err := writeBackup(&buf, Backup{
+		Version:       backupVersion,
+		CreatedAt:     time.Now().UTC(),
+		Tasks:         []*task.Task{running},
+		WorkerTaskMap: map[string][]uuid.UUID{worker: {running.ID}},
+		TaskWorkerMap: map[uuid.UUID]string{running.ID: worker},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t, worker)
+	n := m.nodeFor(worker)
+	n.Disk = 4 << 30
+	if err := m.Import(&buf); err != nil {
+		t.Fatalf("failed to import backup: %v", err)
+	}
+
+	if n.CanAllocatePorts(uuid.NewString(), map[nat.Port]string{"8080/tcp": "30080"}) {
+		t.Error("host port of restored running task can be allocated again")
+	}
+	if available := n.DiskAvailable(); available != 3<<30 {
+		t.Errorf("node has %d bytes of disk available, want %d", available, 3<<30)
+	}
+}
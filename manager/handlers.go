@@ -7,10 +7,14 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/store"
 	"github.com/marktlinn/Gorcherstrator/task"
+	"github.com/marktlinn/Gorcherstrator/worker"
 )
 
 // StartTaskHandler handles requests to initiate a new task. It extracts task details from a JSON-encoded
@@ -35,7 +39,56 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.Manager.AddTask(taskEvent)
+	if err := taskEvent.Task.Validate(); err != nil {
+		errMsg := fmt.Sprintf("invalid task: %s\n", err)
+		log.Println(errMsg)
+		w.WriteHeader(400)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: 400,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
+
+	if persisted, err := a.Manager.TaskDB.Get(taskEvent.Task.ID.String()); err == nil {
+		if err := task.CheckTransition(persisted.State, task.Scheduled); err != nil {
+			errMsg := fmt.Sprintf("task %s cannot be scheduled: %s\n", persisted.ID, err)
+			log.Println(errMsg)
+			w.WriteHeader(409)
+			errRes := ApiErrorResponse{
+				Message:        errMsg,
+				HTTPStatusCode: 409,
+			}
+			if err := json.NewEncoder(w).Encode(errRes); err != nil {
+				log.Printf("error encoding json response: %s\n", err)
+			}
+			return
+		}
+	}
+
+	if taskEvent.ID == uuid.Nil {
+		taskEvent.ID = uuid.New()
+	}
+	if taskEvent.Timestamp.IsZero() {
+		taskEvent.Timestamp = time.Now().UTC()
+	}
+
+	if err := a.Manager.AddTask(taskEvent); err != nil {
+		errMsg := fmt.Sprintf("failed to queue task %s: %s\n", taskEvent.Task.ID, err)
+		log.Println(errMsg)
+		w.WriteHeader(500)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: 500,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
 	w.WriteHeader(201)
 	if err := json.NewEncoder(w).Encode(taskEvent.Task); err != nil {
 		log.Printf("error encoding json response: %s\n", err)
@@ -43,11 +96,39 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetTaskHandler handles requests to retrieve tasks from the Manager.
-// It returns a JSON-encoded list of tasks currently managed by the Manager.
+// It returns a JSON-encoded list of tasks currently managed by the Manager, filtered and paginated
+// by the request's query parameters (see store.ParseTaskQuery). When more results remain, the cursor
+// for the next page is returned in the X-Next-Cursor header.
 func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+
+	q, err := store.ParseTaskQuery(r.URL.Query())
+	if err != nil {
+		errMsg := fmt.Sprintf("invalid task query: %s\n", err)
+		log.Println(errMsg)
+		w.WriteHeader(400)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: 400,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
+
+	tasks, next, err := a.Manager.QueryTasks(q)
+	if err != nil {
+		log.Printf("failed to query tasks: %s\n", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if next != "" {
+		w.Header().Set(store.NextCursorHeader, next)
+	}
 	w.WriteHeader(200)
-	if err := json.NewEncoder(w).Encode(a.Manager.GetTasks()); err != nil {
+	if err := json.NewEncoder(w).Encode(tasks); err != nil {
 		log.Printf("error encoding json response: %s\n", err)
 	}
 }
@@ -70,20 +151,211 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := task.CheckStop(targetTask.State); err != nil {
+		errMsg := fmt.Sprintf("task %s cannot be stopped: %s\n", tID, err)
+		log.Println(errMsg)
+		w.WriteHeader(409)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: 409,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
+
 	taskEvent := task.TaskEvent{
 		ID:        uuid.New(),
 		State:     task.Complete,
 		Timestamp: time.Now(),
 	}
 
-	cpyTargetTask := targetTask.(*task.Task)
-	taskEvent.Task = *cpyTargetTask
-	a.Manager.AddTask(taskEvent)
+	taskEvent.Task = *targetTask
+	if err := a.Manager.AddTask(taskEvent); err != nil {
+		errMsg := fmt.Sprintf("failed to queue stop of task %s: %s\n", tID, err)
+		log.Println(errMsg)
+		w.WriteHeader(500)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: 500,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
 
-	log.Printf("Task %v added to Manager's stop Queue\n", cpyTargetTask.ID)
+	log.Printf("Task %v added to Manager's stop Queue\n", targetTask.ID)
 	w.WriteHeader(204)
 }
 
+// GetTaskEventsHandler handles requests for the history of a single task. It returns a JSON-encoded
+// list of every TaskEvent recorded for the taskID in the request path, oldest first.
+func (a *Api) GetTaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("invalid taskID %q: %s\n", taskID, err)
+		w.WriteHeader(400)
+		return
+	}
+
+	events, err := a.Manager.EventDB.ListByTask(tID.String())
+	if err != nil {
+		log.Printf("failed to list events for task %s: %s\n", tID, err)
+		w.WriteHeader(500)
+		return
+	}
+	if len(events) == 0 {
+		if _, err := a.Manager.TaskDB.Get(tID.String()); err != nil {
+			log.Printf("Failed to find Task with ID: %s\n", tID)
+			w.WriteHeader(404)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		log.Printf("error encoding json response: %s\n", err)
+	}
+}
+
+// GetTaskLogsHandler proxies requests for a Task's logs to the Worker running the Task; see the Worker's
+// GetTaskLogsHandler for the query parameters accepted. Logs are streamed back as the Worker writes them.
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("invalid taskID %q: %s\n", taskID, err)
+		w.WriteHeader(400)
+		return
+	}
+
+	worker, ok := a.Manager.workerFor(tID)
+	if !ok {
+		t, err := a.Manager.TaskDB.Get(tID.String())
+		if err != nil || t.Worker == "" {
+			log.Printf("Failed to find a Worker for Task with ID: %s\n", tID)
+			w.WriteHeader(404)
+			return
+		}
+		worker = t.Worker
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: worker})
+	proxy.FlushInterval = -1
+	proxy.ServeHTTP(w, r)
+}
+
+// ExecTaskHandler checks requests to exec into a Task are authorized and proxies them, and the upgraded
+// connection they ask for, to the Worker running the Task; see worker.Exec for the protocol spoken over it.
+// The Worker authorizes the request again, so it must share the Manager's ExecToken.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if status, err := worker.AuthorizeExec(r, a.ExecToken); err != nil {
+		errMsg := fmt.Sprintf("exec rejected: %s\n", err)
+		log.Println(errMsg)
+		w.WriteHeader(status)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: status,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
+
+	taskID := r.PathValue("taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("invalid taskID %q: %s\n", taskID, err)
+		w.WriteHeader(400)
+		return
+	}
+
+	addr, ok := a.Manager.workerFor(tID)
+	if !ok {
+		log.Printf("Failed to find a Worker running Task with ID: %s\n", tID)
+		w.WriteHeader(404)
+		return
+	}
+
+	log.Printf("proxying exec into task %s to worker %s\n", tID, addr)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: addr})
+	proxy.ServeHTTP(w, r)
+}
+
+// BackupHandler handles requests to export the Manager's full state. It streams a gzip compressed
+// archive which can be loaded into a fresh Manager through RestoreHandler.
+func (a *Api) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := a.Manager.backup()
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to export backup: %s\n", err)
+		log.Println(errMsg)
+		w.WriteHeader(500)
+		errRes := ApiErrorResponse{
+			Message:        errMsg,
+			HTTPStatusCode: 500,
+		}
+		if err := json.NewEncoder(w).Encode(errRes); err != nil {
+			log.Printf("error encoding json response: %s\n", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="gorcherstrator-backup.json.gz"`)
+	if err := writeBackup(w, b); err != nil {
+		log.Printf("failed to write backup: %s\n", err)
+	}
+}
+
+// RestoreHandler handles requests to import an archive produced by BackupHandler into the Manager.
+// The Manager must not hold any Tasks, TaskEvents or queued work yet.
+func (a *Api) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	err := a.Manager.Import(r.Body)
+	if err == nil {
+		log.Println("Manager state restored from backup")
+		w.WriteHeader(204)
+		return
+	}
+
+	errMsg := fmt.Sprintf("failed to restore backup: %s\n", err)
+	log.Println(errMsg)
+	status := 400
+	if errors.Is(err, ErrNotFresh) {
+		status = 409
+	}
+	w.WriteHeader(status)
+	errRes := ApiErrorResponse{
+		Message:        errMsg,
+		HTTPStatusCode: status,
+	}
+	if err := json.NewEncoder(w).Encode(errRes); err != nil {
+		log.Printf("error encoding json response: %s\n", err)
+	}
+}
+
+// GetClusterHandler handles requests for the Manager's view of its cluster: its role, the current
+// leader and how far it has applied the replicated log. It returns 404 if the Manager is not replicated.
+func (a *Api) GetClusterHandler(w http.ResponseWriter, r *http.Request) {
+	status, ok := a.Manager.ClusterStatus()
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("error encoding json response: %s\n", err)
+	}
+}
+
 // GetTasks is a helper function which constructs and returns a slice of
 // pointers to the tasks in the Manager's DB.
 func (m *Manager) GetTasks() []*task.Task {
@@ -92,5 +364,18 @@ func (m *Manager) GetTasks() []*task.Task {
 		log.Printf("failed to get list of tasks in %v\n", err)
 	}
 
-	return tasksList.([]*task.Task)
+	return tasksList
+}
+
+// QueryTasks returns the page of Tasks in the Manager's DB matching the given query,
+// along with the cursor for the next page.
+func (m *Manager) QueryTasks(q store.TaskQuery) ([]*task.Task, string, error) {
+	tasks, next, err := store.QueryTasks(m.TaskDB, q)
+	if err != nil {
+		return nil, "", err
+	}
+	if tasks == nil {
+		tasks = []*task.Task{}
+	}
+	return tasks, next, nil
 }
@@ -5,10 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-connections/nat"
@@ -18,9 +19,14 @@ import (
 	"github.com/marktlinn/Gorcherstrator/scheduler"
 	"github.com/marktlinn/Gorcherstrator/store"
 	"github.com/marktlinn/Gorcherstrator/task"
+	"github.com/marktlinn/Gorcherstrator/wal"
 	"github.com/marktlinn/Gorcherstrator/worker"
 )
 
+// startTimeout is how long a Worker may take to start a Task it has been sent, or to restart one on top of the
+// Task's grace period, before the Manager gives up on it. It allows for the Worker pulling the Task's image.
+const startTimeout = 15 * time.Minute
+
 // Manager sits above the Worker and tracks of the Workers in the cluster.
 // The Manager's responsibilities include:
 // - providing the API which allows users to start and stop Tasks.
@@ -34,13 +40,30 @@ type Manager struct {
 	LastWorker int
 	Pending    queue.Queue
 	// TaskDB holds references to all Tasks data in a datastore.
-	TaskDB store.Store
-	// EventDB holds references to Tasks' metadata in a datastore.
-	EventDB store.Store
+	TaskDB store.Store[*task.Task]
+	// EventDB holds references to Tasks' metadata in a datastore, indexed by Task.
+	EventDB store.TaskEventStore
 	// A slice of worker nodes.
 	WorkerNodes []*node.Node
 	// The Scheduler type to be used for scheduling Tasks.
 	Scheduler scheduler.Scheduler
+	// TaskRetention bounds how long, and how many, Complete and Failed Tasks are kept in the TaskDB.
+	TaskRetention store.RetentionPolicy
+	// EventRetention bounds how long, and how many, TaskEvents are kept in the EventDB.
+	EventRetention store.RetentionPolicy
+
+	// mu guards Pending, WorkerTaskMap, TaskWorkerMap and LastWorker.
+	mu sync.Mutex
+	// seq is the sequence number of the last change made to the state guarded by mu.
+	seq uint64
+	// stateLog is the write-ahead log of changes to the state guarded by mu. It is nil
+	// when the Manager's state is not persisted.
+	stateLog *wal.Log
+	// replica replicates the Manager's stores and the state guarded by mu through a Raft log.
+	// It is nil unless the Manager was created by NewReplicated.
+	replica *replica
+	// queued wakes ProcessTasks when AddTask queues a TaskEvent.
+	queued chan struct{}
 }
 
 // New instantiates a new Manager and returns a pointer to the newly
@@ -67,24 +90,68 @@ func New(workers []string, schedulerType, dbType string) *Manager {
 		WorkerTaskMap: workerTaskMap,
 		Scheduler:     s,
 		WorkerNodes:   nodes,
-	}
-
-	var taskStore store.Store
-	var eventStore store.Store
+		queued:        make(chan struct{}, 1),
+		TaskRetention: store.RetentionPolicy{
+			MaxAge:   24 * time.Hour,
+			MaxCount: 1000,
+		},
+		EventRetention: store.RetentionPolicy{
+			MaxAge:   72 * time.Hour,
+			MaxCount: 10000,
+		},
+	}
+
+	var taskStore store.Store[*task.Task]
+	var eventStore store.TaskEventStore
 	switch dbType {
 	case store.MEMORY:
 		taskStore = store.NewInMemoryTaskStore()
 		eventStore = store.NewInMemoryEventStore()
+	case store.PERSISTENT:
+		ts, err := store.NewTaskStore("manager_tasks.db", 0600, "tasks")
+		if err != nil {
+			log.Printf("failed to create task store: %s\n", err)
+			return nil
+		}
+		es, err := store.NewEventStore("manager_events.db", 0600, "events")
+		if err != nil {
+			log.Printf("failed to create event store: %s\n", err)
+			return nil
+		}
+		taskStore = ts
+		eventStore = es
+	default:
+		log.Printf("failed to create stores of type '%s'\n", dbType)
+		return nil
 	}
 
 	m.TaskDB = taskStore
 	m.EventDB = eventStore
+
+	if dbType == store.PERSISTENT {
+		if err := m.recoverState(); err != nil {
+			log.Printf("failed to recover manager state: %s\n", err)
+			return nil
+		}
+	}
 	return &m
 }
 
 // SelectWorker makes use of the Scheduler interface to to nominate an appropriate Worker to receive a Task. If no Worker is found, or no appropriate candidates are given an error is returned.
+// Only Workers whose Node can allocate the host ports the Task requests are considered.
 func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
-	candidates := m.Scheduler.SelectCandidateNodes(t, m.WorkerNodes)
+	reqs, err := t.PortRequests()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*node.Node
+	for _, n := range m.WorkerNodes {
+		if n.CanAllocatePorts(t.ID.String(), reqs) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	candidates := m.Scheduler.SelectCandidateNodes(t, nodes)
 	if candidates == nil {
 		errMsg := fmt.Sprintf("failed to find available candidates for task %s\n", t.ID)
 		return nil, errors.New(errMsg)
@@ -97,53 +164,100 @@ func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
 }
 
 // SendWork organises the distribution of Tasks amongst the Workers and updates the state of the Task.
+// A TaskEvent asking for a Task to be Complete stops it; any other schedules it. Events asking for a change
+// of State the Task's current State does not allow are dropped. A Task which no Worker can run is
+// Unschedulable, and its event is requeued to try again.
+// The TaskEvent is only removed from the queue once it has been dealt with, so a Manager which stops part way
+// through sends it again once it recovers.
 func (m *Manager) SendWork() {
-	if m.Pending.Len() <= 0 {
+	taskEvent, ok := m.peek()
+	if !ok {
 		log.Println("Queue is empty, no Tasks to process.")
 		return
 	}
+	defer m.dequeue()
 
-	t := m.Pending.Dequeue()
-	taskEvent := t.(task.TaskEvent)
-
-	err := m.EventDB.Put(taskEvent.ID.String(), &taskEvent)
-	if err != nil {
-		log.Printf("failed to insert task into store %s: %s\n", taskEvent.ID, err)
+	current := task.Pending
+	persistedTask, err := m.TaskDB.Get(taskEvent.Task.ID.String())
+	if err == nil {
+		current = persistedTask.State
 	}
 
-	taskWorker, ok := m.TaskWorkerMap[taskEvent.Task.ID]
-	if ok {
-		res, err := m.TaskDB.Get(taskEvent.Task.ID.String())
-		if err != nil {
-			log.Printf("failed to schedule task: %s\n", err)
+	if taskEvent.State == task.Complete {
+		if err := task.CheckStop(current); err != nil {
+			log.Printf("dropping request to stop task %s: %s\n", taskEvent.Task.ID, err)
 			return
 		}
-		persistedTask, ok := res.(*task.Task)
-		if !ok {
-			log.Printf("failed to convert task %v to type task.Task\n", res)
-			return
-		}
-
-		if taskEvent.State == task.Complete &&
-			task.ValidStateTransition(persistedTask.State, taskEvent.State) {
-			m.stopTask(taskWorker, taskEvent.Task.ID.String())
-			return
+		switch {
+		case current == task.Stopping:
+			log.Printf("task %s is already stopping\n", taskEvent.Task.ID)
+		case !task.ValidStateTransition(current, task.Stopping):
+			// The Task has not been scheduled on a Worker yet.
+			if _, err := m.transition(taskEvent.Task.ID, task.Complete, "stopped before it was scheduled"); err != nil {
+				log.Printf("failed to stop task %s: %s\n", taskEvent.Task.ID, err)
+			}
+		default:
+			generation := persistedTask.Generation + 1
+			if taskWorker, ok := m.workerFor(taskEvent.Task.ID); ok && m.stopTask(taskWorker, taskEvent.Task.ID.String(), generation) {
+				m.markStopping(persistedTask.ID, generation)
+			} else if current == task.Scheduled {
+				// The Worker may not have stored the Task yet, such as while it pulls the Task's image, so the
+				// request is retried until the Task is Running, or Lost.
+				m.enqueue(taskEvent)
+			}
 		}
+		return
 	}
 
 	tsk := taskEvent.Task
+	tsk.State = current
+	if err == nil {
+		tsk.Generation = persistedTask.Generation
+	}
+	if err := task.CheckTransition(current, task.Scheduled); err != nil {
+		log.Printf("dropping request to schedule task %s: %s\n", tsk.ID, err)
+		return
+	}
+
+	// A Task whose Worker could not be reached to restart it is rescheduled from scratch.
+	if current == task.Restarting {
+		m.release(tsk.ID)
+		m.unassign(tsk.ID)
+		tsk.Worker = ""
+	}
 	w, err := m.SelectWorker(tsk)
+	if err == nil {
+		var ports map[nat.Port]string
+		if ports, err = allocate(w, tsk); err == nil {
+			tsk.PortBindings = portBindings(ports)
+		}
+	}
 	if err != nil {
-		log.Printf("failed to select Worker for task %s: %s\n", taskEvent.ID, err)
+		log.Printf("failed to schedule task %s: %s\n", tsk.ID, err)
+		m.markUnschedulable(tsk, err)
+		m.enqueue(taskEvent)
 		return
 	}
-	m.WorkerTaskMap[w.Name] = append(m.WorkerTaskMap[w.Name], taskEvent.Task.ID)
-	m.TaskWorkerMap[tsk.ID] = w.Name
+	requested := taskEvent.Task.PortBindings
+	m.assign(tsk.ID, w.Name)
 
-	tsk.State = task.Scheduled
+	tsk.Worker = w.Name
+	tsk.Generation++
+	taskEvent.Task.Worker = w.Name
+	taskEvent.Task.Generation = tsk.Generation
+	taskEvent.Task.PortBindings = tsk.PortBindings
+	if err := tsk.Transition(task.Scheduled, fmt.Sprintf("scheduled on worker %s", w.Name)); err != nil {
+		log.Printf("task %s: %s\n", tsk.ID, err)
+	}
+	taskEvent.Task.State = tsk.State
+	taskEvent.Task.StateReason = tsk.StateReason
+	taskEvent.Task.StateTime = tsk.StateTime
 	if putErr := m.TaskDB.Put(tsk.ID.String(), &tsk); putErr != nil {
 		log.Printf("failed to put task %s in taskDB: %s\n", tsk.ID, putErr)
 	}
+	if current != task.Scheduled {
+		m.recordEvent(tsk, task.Scheduled, tsk.StateReason)
+	}
 
 	data, marshalErr := json.Marshal(taskEvent)
 	if marshalErr != nil {
@@ -154,23 +268,38 @@ func (m *Manager) SendWork() {
 	res, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		log.Printf("failed to connect %s; %s\n", url, err)
-		m.Pending.Enqueue(taskEvent)
+		m.release(tsk.ID)
+		taskEvent.Task.PortBindings = requested
+		m.enqueue(taskEvent)
 		return
 	}
 
 	d := json.NewDecoder(res.Body)
+	if res.StatusCode == http.StatusServiceUnavailable {
+		log.Printf("worker %s is not ready, requeuing task %s\n", w.Name, tsk.ID)
+		m.release(tsk.ID)
+		taskEvent.Task.PortBindings = requested
+		m.enqueue(taskEvent)
+		return
+	}
 	if res.StatusCode != http.StatusCreated {
+		// The Worker will not run the Task, which fails rather than staying Scheduled for good.
+		reason := fmt.Sprintf("worker %s refused the task with StatusCode %d", w.Name, res.StatusCode)
 		e := worker.ApiErrorResponse{}
-		err := d.Decode(&e)
-		if err != nil {
+		if err := d.Decode(&e); err != nil {
 			log.Printf("failed to decode response %s\n", err)
-			return
+		} else {
+			log.Printf("error response: %d; %s\n", e.HTTPStatusCode, e.Message)
+			reason = fmt.Sprintf("worker %s refused the task: %s", w.Name, strings.TrimSpace(e.Message))
+		}
+		if _, err := m.transition(tsk.ID, task.Failed, reason); err != nil {
+			log.Printf("failed to fail task %s: %s\n", tsk.ID, err)
+			m.release(tsk.ID)
 		}
-		log.Printf("error response: %d; %s\n", e.HTTPStatusCode, e.Message)
 		return
 	}
 
-	t = task.Task{}
+	t := task.Task{}
 	err = d.Decode(&t)
 	if err != nil {
 		log.Printf("failed to decode response: %s\n", err)
@@ -179,19 +308,23 @@ func (m *Manager) SendWork() {
 }
 
 // UpdateTasks intermittently quiries Workers to retrieve their current state.
-// Each Worker's current state is updated in the Manager's TaskDB.
+// Each Worker's current state is updated in the Manager's TaskDB. A replicated Manager
+// only queries Workers while it is the leader.
 func (m *Manager) UpdateTasks() {
 	var rest time.Duration = 15
 	for {
-		log.Println("checking for task updates in Workers")
-		m.updateTasks()
-		log.Printf("Tasks updated; resuming in %d seconds\n", rest)
+		if m.IsLeader() {
+			log.Println("checking for task updates in Workers")
+			m.updateTasks()
+			log.Printf("Tasks updated; resuming in %d seconds\n", rest)
+		}
 		time.Sleep(rest * time.Second)
 	}
 }
 
 // updateTasks is a helper function that gets all tasks from each Worker, then ensures the state of each Task
-// is in sync with the TaskDB store.
+// is in sync with the TaskDB store. Tasks a Worker should be running, but no longer knows of, are Lost, and
+// Tasks a Worker has taken too long to restart are Failed.
 func (m *Manager) updateTasks() {
 	for _, worker := range m.Workers {
 		log.Printf("getting tasks from worker %v\n", worker)
@@ -204,102 +337,315 @@ func (m *Manager) updateTasks() {
 				url,
 				err,
 			)
+			continue
 		}
-		defer res.Body.Close()
 
 		if res.StatusCode != http.StatusOK {
-			fmt.Printf("failed to send request: %s\n", err)
+			fmt.Printf("failed to get tasks from worker %s: StatusCode %d\n", worker, res.StatusCode)
+			res.Body.Close()
+			continue
 		}
 
 		data := json.NewDecoder(res.Body)
 		var t []*task.Task
 		err = data.Decode(&t)
-		if err != nil && errors.Is(err, io.EOF) {
+		res.Body.Close()
+		if err != nil {
 			fmt.Printf("failed to unmarshall task data: %s\n", err)
+			continue
 		}
 		updateCollectedTasks(t, m)
+		m.markLost(worker, t)
+	}
+	m.expireRestarts()
+}
+
+// markLost marks the Tasks the Worker should be running, but which are missing from those it reported, as
+// Lost. Scheduled Tasks are only expected to be reported once the Worker has had the startTimeout to start
+// them, and not while they are queued to be sent to it again.
+func (m *Manager) markLost(worker string, reported []*task.Task) {
+	known := make(map[uuid.UUID]bool, len(reported))
+	for _, t := range reported {
+		known[t.ID] = true
+	}
+
+	tasks, err := m.TaskDB.List()
+	if err != nil {
+		log.Printf("failed to list tasks: %s\n", err)
+		return
+	}
+	for _, t := range tasks {
+		if known[t.ID] || t.Worker != worker {
+			continue
+		}
+		switch t.State {
+		case task.Running, task.Stopping, task.Restarting:
+		case task.Scheduled:
+			if time.Since(t.StateTime) < startTimeout || m.isPending(t.ID) {
+				continue
+			}
+		default:
+			continue
+		}
+		reason := fmt.Sprintf("worker %s no longer knows of the task", worker)
+		if t.State == task.Scheduled {
+			reason = fmt.Sprintf("worker %s did not start the task within %s", worker, startTimeout)
+		}
+		if _, err := m.transition(t.ID, task.Lost, reason); err != nil {
+			log.Printf("failed to mark task %s as lost: %s\n", t.ID, err)
+		}
+	}
+}
+
+// expireRestarts fails the Tasks which have been Restarting for longer than their grace period and the
+// startTimeout, such as one whose Worker could not record the restart, asking their Worker to stop them
+// first in case their new container is running after all. Failing the Task is a request of its own, so that
+// the Worker's reports from before it are not taken to restart the Task again.
+func (m *Manager) expireRestarts() {
+	tasks, err := m.TaskDB.List()
+	if err != nil {
+		log.Printf("failed to list tasks: %s\n", err)
+		return
+	}
+	for _, t := range tasks {
+		timeout := t.StopOptions().Timeout + startTimeout
+		if t.State != task.Restarting || time.Since(t.StateTime) < timeout {
+			continue
+		}
+		generation := t.Generation + 1
+		if taskWorker, ok := m.workerFor(t.ID); ok {
+			m.stopTask(taskWorker, t.ID.String(), generation)
+		}
+		reason := fmt.Sprintf("worker %s did not restart the task within %s", t.Worker, timeout)
+		failed, err := store.Update(m.TaskDB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+			if persisted.Generation != t.Generation {
+				return nil, fmt.Errorf("task %s changed while its restart expired", t.ID)
+			}
+			if err := persisted.Transition(task.Failed, reason); err != nil {
+				return nil, err
+			}
+			persisted.Generation = generation
+			return persisted, nil
+		})
+		if err != nil {
+			log.Printf("failed to fail task %s: %s\n", t.ID, err)
+			continue
+		}
+		m.recordEvent(*failed, task.Failed, reason)
+		m.release(t.ID)
 	}
 }
 
 // stopTask is a helper function helping connect to the correct Worker where a Task is running and scheduling for that Task to be gracefully terminated.
-func (m *Manager) stopTask(worker, taskID string) {
+// The request is the Task's given Generation. It reports whether the Worker accepted the request.
+func (m *Manager) stopTask(worker, taskID string, generation uint64) bool {
 	client := &http.Client{}
-	url := fmt.Sprintf("http://%s/tasks/%s", worker, taskID)
+	url := fmt.Sprintf("http://%s/tasks/%s?generation=%d", worker, taskID, generation)
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		log.Printf("failed to create deletion request for task %s\n", taskID)
-		return
+		return false
 	}
 
 	res, err := client.Do(req)
 	if err != nil {
 		log.Printf("failed to connect to Worker %s at %s\n", worker, url)
+		return false
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 204 {
-		log.Printf("failed to send request: %s\n", err)
-		return
+		log.Printf("failed to stop task %s, unexpected HTTP Status %d received\n", taskID, res.StatusCode)
+		return false
 	}
 
 	log.Printf("task %s successfully scheduled to be stopped\n", taskID)
+	return true
+}
+
+// markStopping records that a running Task has been asked to stop, in the request of the given Generation, so
+// the Task shows as Stopping while its Worker gives it its grace period.
+func (m *Manager) markStopping(taskID uuid.UUID, generation uint64) {
+	var reason string
+	updated, err := store.Update(m.TaskDB, taskID.String(), func(persisted *task.Task) (*task.Task, error) {
+		persisted.Generation = max(persisted.Generation, generation)
+		// The Worker may already have reported the Task as Stopping, or as stopped.
+		if persisted.State == task.Stopping || task.IsTerminal(persisted.State) {
+			return persisted, nil
+		}
+		opts := persisted.StopOptions()
+		reason = fmt.Sprintf("stopping with %s and a grace period of %s", opts.Signal, opts.Timeout)
+		if err := persisted.Transition(task.Stopping, reason); err != nil {
+			return nil, fmt.Errorf("task %s: %w", taskID, err)
+		}
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to mark task %s as stopping: %s\n", taskID, err)
+		return
+	}
+	if reason != "" {
+		m.recordEvent(*updated, task.Stopping, reason)
+	}
+}
+
+// markUnschedulable records that no Worker can currently run the Task, and why.
+func (m *Manager) markUnschedulable(t task.Task, cause error) {
+	if t.State == task.Unschedulable {
+		return
+	}
+	if err := t.Transition(task.Unschedulable, strings.TrimSpace(cause.Error())); err != nil {
+		log.Printf("task %s: %s\n", t.ID, err)
+		return
+	}
+	if err := m.TaskDB.Put(t.ID.String(), &t); err != nil {
+		log.Printf("failed to put task %s in taskDB: %s\n", t.ID, err)
+		return
+	}
+	m.recordEvent(t, task.Unschedulable, t.StateReason)
 }
 
-// ProcessTasks, at the determined interval, processes the work on the Manager's queue.
+// transition moves the Task to the State in the TaskDB, unless that is not a valid transition from its
+// current State, and records the reason in the Task's history. What is allocated to the Task is released
+// once it has finished.
+func (m *Manager) transition(taskID uuid.UUID, state task.State, reason string) (*task.Task, error) {
+	updated, err := store.Update(m.TaskDB, taskID.String(), func(persisted *task.Task) (*task.Task, error) {
+		if err := persisted.Transition(state, reason); err != nil {
+			return nil, fmt.Errorf("task %s: %w", taskID, err)
+		}
+		return persisted, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.recordEvent(*updated, state, reason)
+	if task.IsTerminal(state) {
+		m.release(taskID)
+	}
+	return updated, nil
+}
+
+// ProcessTasks processes the work on the Manager's queue. Work is processed as soon as AddTask queues a
+// TaskEvent, and otherwise at the determined interval, which is all work the Manager requeues itself waits for. A replicated Manager
+// only processes work while it is the leader, and rebuilds its Nodes' allocations from the
+// TaskDB each time it becomes the leader.
 func (m *Manager) ProcessTasks() {
 	var rest time.Duration = 10
+	leading := false
 	for {
-		log.Println("Processing tasks in Manager queue")
-		m.SendWork()
-		log.Printf("Processing complete; resuming in %d seconds\n", rest)
-		time.Sleep(rest * time.Second)
+		if m.IsLeader() {
+			if !leading {
+				m.rebuildAllocations()
+			}
+			log.Println("Processing tasks in Manager queue")
+			m.SendWork()
+			log.Printf("Processing complete; resuming in %d seconds or once a task is queued\n", rest)
+		}
+		leading = m.IsLeader()
+
+		select {
+		case <-m.queued:
+			log.Println("task queued")
+		case <-time.After(rest * time.Second):
+		}
 	}
 }
 
-// AddTask adds Tasks to the Manager's queue.
-func (m *Manager) AddTask(te task.TaskEvent) {
+// AddTask records the TaskEvent in the EventDB and adds it to the Manager's queue, returning an error if it
+// could not be queued. ProcessTasks is woken to deal with it straight away.
+func (m *Manager) AddTask(te task.TaskEvent) error {
 	log.Printf("adding task: %+v\n", te)
-	m.Pending.Enqueue(te)
+	if err := m.enqueue(te); err != nil {
+		return err
+	}
+	select {
+	case m.queued <- struct{}{}:
+	default:
+		// ProcessTasks has yet to wake for an earlier TaskEvent, and processes this one with it.
+	}
+	if err := m.EventDB.Put(te.ID.String(), &te); err != nil {
+		log.Printf("failed to insert task event into store %s: %s\n", te.ID, err)
+	}
+	return nil
 }
 
 // updateCollectedTasks loops through the slice of provided tasks
 // and synchronises the the state of the Task with the state of the Task
-// of matching ID in the Manager's TaskDB.
+// of matching ID in the Manager's TaskDB. Each Task is updated with a compare-and-swap so
+// that a concurrent restart is never overwritten by a stale copy, and a Task the Worker reports as it was before
+// the Manager's latest request for it, as its Generation shows, keeps the State the Manager gave it.
 func updateCollectedTasks(tasks []*task.Task, m *Manager) {
 	for _, t := range tasks {
 		log.Printf("updating tasks...")
-		res, err := m.TaskDB.Get(t.ID.String())
+		var previous task.State
+		updated, err := store.Update(m.TaskDB, t.ID.String(), func(taskPersisted *task.Task) (*task.Task, error) {
+			previous = taskPersisted.State
+			// A Task the Manager has asked to stop or restart may be reported as it was until its Worker gets
+			// to it, which a Worker's report of an earlier Generation, or one that is not a valid transition, shows.
+			stale := t.Generation < taskPersisted.Generation
+			if taskPersisted.State != t.State && !stale {
+				if err := task.CheckTransition(taskPersisted.State, t.State); err != nil {
+					log.Printf("ignoring state reported for task %s by worker %s: %s\n", t.ID, t.Worker, err)
+				} else {
+					taskPersisted.State = t.State
+					taskPersisted.StateReason = t.StateReason
+					taskPersisted.StateTime = t.StateTime
+				}
+			}
+
+			taskPersisted.StartTime = t.StartTime
+			taskPersisted.FinishTime = t.FinishTime
+			taskPersisted.ExitCode = t.ExitCode
+			taskPersisted.Ready = t.Ready
+			taskPersisted.ContainerID = t.ContainerID
+			taskPersisted.HostPorts = t.HostPorts
+			taskPersisted.DiskUsed = t.DiskUsed
+			taskPersisted.PullEvents = t.PullEvents
+			// The Worker counts the restarts its probes make, and the Manager those it asks for.
+			taskPersisted.RestartCount = max(taskPersisted.RestartCount, t.RestartCount)
+			return taskPersisted, nil
+		})
 		if err != nil {
-			log.Printf("failed to get task for Manager %s\n", err)
+			log.Printf("failed to update task %s in taskDB: %s\n", t.ID, err)
 			continue
 		}
-
-		taskPersisted, ok := res.(*task.Task)
-		if !ok {
-			log.Printf("failed to convert %v to type task.Task\n", res)
+		if task.IsTerminal(updated.State) {
+			m.release(updated.ID)
 		}
-		if taskPersisted.State != t.State {
-			taskPersisted.State = t.State
-		}
-
-		taskPersisted.StartTime = t.StartTime
-		taskPersisted.FinishTime = t.FinishTime
-		taskPersisted.ContainerID = t.ContainerID
-		taskPersisted.HostPorts = t.HostPorts
 
-		if putErr := m.TaskDB.Put(taskPersisted.ID.String(), taskPersisted); putErr != nil {
-			log.Printf("failed to put task %s in taskDB: %s\n", taskPersisted.ID, putErr)
+		if previous != updated.State {
+			reason := fmt.Sprintf("worker %s reported transition from %s to %s: %s", t.Worker, previous, updated.State, updated.StateReason)
+			m.recordEvent(*updated, updated.State, reason)
 		}
 	}
 }
 
+// recordEvent stores a TaskEvent in the EventDB describing a change the Manager observed for the Task,
+// so that it appears in the Task's history.
+func (m *Manager) recordEvent(t task.Task, state task.State, reason string) {
+	event := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     state,
+		Task:      t,
+		Timestamp: time.Now().UTC(),
+		Reason:    reason,
+	}
+	if err := m.EventDB.Put(event.ID.String(), &event); err != nil {
+		log.Printf("failed to record event for task %s: %s\n", t.ID, err)
+	}
+}
+
 // RunHealthChecks ensures running tasks are pinged at a setinterval to ensure they are running correctly.
 // A task found to be in any State other than `Running` will be restarted.
 // Tasks that repeatedly fail (3 or more times) will be set to a `failed` state.
+// A replicated Manager only runs HealthChecks while it is the leader.
 func (m *Manager) RunHealthChecks() {
 	var rest time.Duration = 60
 	for {
-		m.runHealthCheck()
-		log.Printf("HealthChecks complete, next cycle will start in %d seconds.\n", rest)
+		if m.IsLeader() {
+			m.runHealthCheck()
+			log.Printf("HealthChecks complete, next cycle will start in %d seconds.\n", rest)
+		}
 		time.Sleep(rest * time.Second)
 	}
 }
@@ -307,16 +653,15 @@ func (m *Manager) RunHealthChecks() {
 // runHealthCheck loops over all Tasks on the Worker performing HealthChecks.
 // If a task is not in a `Running` state and its RestartCount is less that 3,
 // and attempt will be made to restarted it. Else the Task's State will be set to False to indicate it has failed.
+// Batch Tasks are not health checked, as they are expected to exit, and neither are Tasks with a
+// LivenessProbe, which their Worker checks instead.
 func (m *Manager) runHealthCheck() {
 	for _, t := range m.GetTasks() {
-		if t.State == task.Running && t.RestartCount < 3 {
+		if t.State == task.Running && t.RestartCount < 3 && !t.IsBatch() && t.LivenessProbe == nil {
 			if err := m.healthCheckTask(*t); err != nil {
-				m.restartTask(t)
+				m.restartTask(t, fmt.Sprintf("health check failed: %s", err))
 				return
 			}
-			if t.State == task.Failed {
-				m.restartTask(t)
-			}
 		}
 	}
 }
@@ -325,7 +670,7 @@ func (m *Manager) runHealthCheck() {
 func (m *Manager) healthCheckTask(t task.Task) error {
 	log.Printf("Performing HealtCheck on Task %+v\n", t)
 
-	wTask := m.TaskWorkerMap[t.ID]
+	wTask, _ := m.workerFor(t.ID)
 	hostPort := getHostPort(t.HostPorts)
 	if hostPort == nil {
 		return fmt.Errorf("hostPort is nil")
@@ -350,28 +695,63 @@ func (m *Manager) healthCheckTask(t task.Task) error {
 	return nil
 }
 
-// getPorts is a auxiliary function to retrieve the a HostPort from a selected Task.
+// getPorts is a auxiliary function to retrieve the a HostPort from a selected Task: that of its lowest
+// published port.
 func getHostPort(ports nat.PortMap) *string {
+	keys := make([]nat.Port, 0, len(ports))
 	for k := range ports {
-		return &ports[k][0].HostPort
+		if len(ports[k]) > 0 {
+			keys = append(keys, k)
+		}
 	}
-	return nil
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	return &ports[keys[0]][0].HostPort
 }
 
 // restartTasks targets the give task and attempts to restart it, outputting logs for any errors that occur while attempting the restart.
-func (m *Manager) restartTask(t *task.Task) {
-	wTask := m.TaskWorkerMap[t.ID]
-	t.State = task.Scheduled
-	t.RestartCount++
+// The Task is Restarting until its Worker reports it running in a new container, and is rescheduled if its
+// Worker cannot be reached.
+func (m *Manager) restartTask(t *task.Task, reason string) {
+	wTask, _ := m.workerFor(t.ID)
+	restartCount := t.RestartCount
+
+	// The Task is restarted on the same host ports, which must still be allocated to it.
+	if n := m.nodeFor(wTask); n != nil {
+		if _, err := allocate(n, *t); err != nil {
+			log.Printf("failed to allocate resources to restart task %s: %s\n", t.ID, err)
+			return
+		}
+	}
 
-	if putErr := m.TaskDB.Put(t.ID.String(), t); putErr != nil {
-		log.Printf("failed to put task %s in taskDB: %s\n", t.ID, putErr)
+	restarted, err := store.Update(m.TaskDB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		if persisted.RestartCount != restartCount {
+			return nil, fmt.Errorf("task %s was restarted concurrently", t.ID)
+		}
+		if err := persisted.Transition(task.Restarting, reason); err != nil {
+			return nil, err
+		}
+		persisted.RestartCount++
+		persisted.Generation++
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to update task %s in taskDB: %s\n", t.ID, err)
+		return
 	}
+	*t = *restarted
+
 	taskEvent := task.TaskEvent{
 		ID:        uuid.New(),
 		Task:      *t,
-		State:     task.Running,
-		Timestamp: time.Now(),
+		State:     task.Restarting,
+		Timestamp: time.Now().UTC(),
+		Reason:    fmt.Sprintf("restart %d on worker %s: %s", t.RestartCount, wTask, reason),
+	}
+	if err := m.EventDB.Put(taskEvent.ID.String(), &taskEvent); err != nil {
+		log.Printf("failed to record restart of task %s: %s\n", t.ID, err)
 	}
 
 	data, err := json.Marshal(taskEvent)
@@ -380,9 +760,9 @@ func (m *Manager) restartTask(t *task.Task) {
 	}
 
 	url := fmt.Sprintf("http://%s/tasks", wTask)
-	res, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
-		m.Pending.Enqueue(t)
+		m.enqueue(taskEvent)
 		log.Printf(
 			"failed to connect to Worker for task: %s, requeuing task; err: %s\n",
 			wTask,
@@ -391,8 +771,11 @@ func (m *Manager) restartTask(t *task.Task) {
 		return
 	}
 
-	d := json.NewDecoder(res.Body)
-	if res.StatusCode != http.StatusCreated {
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		// A Worker which cannot restart the Task, such as one which rejects the transition, leaves it to be
+		// rescheduled, rather than Restarting for good.
+		m.enqueue(taskEvent)
 		e := worker.ApiErrorResponse{}
 		err := d.Decode(&e)
 		if err != nil {
@@ -400,9 +783,9 @@ func (m *Manager) restartTask(t *task.Task) {
 			return
 		}
 		log.Printf(
-			"failed to create task, unexpected HTTP Status %d received: %s\n",
-			res.StatusCode,
-			err,
+			"failed to restart task, unexpected HTTP Status %d received: %s\n",
+			resp.StatusCode,
+			e.Message,
 		)
 
 		return
@@ -414,3 +797,42 @@ func (m *Manager) restartTask(t *task.Task) {
 		fmt.Printf("failed to decode New Task %+v: %s\n", newTask, err)
 	}
 }
+
+// CollectGarbage periodically prunes the finished Tasks and the TaskEvents that fall outside
+// the Manager's TaskRetention and EventRetention policies.
+func (m *Manager) CollectGarbage() {
+	var rest time.Duration = 300
+	for {
+		if m.IsLeader() {
+			report := m.collectGarbage()
+			log.Printf("Garbage collection complete: %s; next run in %d seconds\n", report, rest)
+		}
+		time.Sleep(rest * time.Second)
+	}
+}
+
+// collectGarbage runs a single pruning pass over the TaskDB and EventDB and reports what it removed.
+// Pruned Tasks are also forgotten by the Manager's task/worker maps and Node allocations.
+func (m *Manager) collectGarbage() store.PruneReport {
+	var report store.PruneReport
+	now := time.Now().UTC()
+
+	tasks, err := store.PruneTasks(m.TaskDB, m.TaskRetention, now)
+	if err != nil {
+		log.Printf("failed to prune tasks: %s\n", err)
+	}
+	report.Tasks = tasks
+	for _, id := range tasks {
+		if tID, err := uuid.Parse(id); err == nil {
+			m.unassign(tID)
+			m.release(tID)
+		}
+	}
+
+	events, err := store.PruneEvents(m.EventDB, m.EventRetention, now)
+	if err != nil {
+		log.Printf("failed to prune events: %s\n", err)
+	}
+	report.Events = events
+	return report
+}
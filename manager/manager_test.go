@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/task"
+	"github.com/marktlinn/Gorcherstrator/worker"
+)
+
+// newTestWorker serves a Worker Api which answers every request with the handler.
+func newTestWorker(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestRefusedTaskFails(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(worker.ApiErrorResponse{HTTPStatusCode: 400, Message: "bind mount not allowed"})
+	})
+	m := newTestManager(t, w)
+	id := uuid.New()
+	if err := m.AddTask(task.TaskEvent{
+		ID:    uuid.New(),
+		State: task.Scheduled,
+		Task:  task.Task{ID: id, Name: "web", Image: "nginx", PortBindings: map[string]string{"80/tcp": "30080"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m.SendWork()
+
+	refused, err := m.TaskDB.Get(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refused.State != task.Failed || !strings.Contains(refused.StateReason, "bind mount not allowed") {
+		t.Errorf("refused task is %s because %q, want %s with the worker's reason", refused.State, refused.StateReason, task.Failed)
+	}
+	if !m.nodeFor(w).CanAllocatePorts(uuid.NewString(), map[nat.Port]string{"80/tcp": "30080"}) {
+		t.Error("host port of refused task is still allocated")
+	}
+}
+
+func TestScheduledTaskNeverStartedIsLost(t *testing.T) {
+	const w = "127.0.0.1:1"
+	m := newTestManager(t, w)
+	recent := &task.Task{ID: uuid.New(), State: task.Scheduled, StateTime: time.Now().UTC().Add(-time.Minute), Worker: w}
+	stale := &task.Task{ID: uuid.New(), State: task.Scheduled, StateTime: time.Now().UTC().Add(-startTimeout - time.Minute), Worker: w}
+	for _, tk := range []*task.Task{recent, stale} {
+		if err := m.TaskDB.Put(tk.ID.String(), tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The Worker reports neither Task.
+	m.markLost(w, nil)
+
+	for _, tt := range []struct {
+		tk   *task.Task
+		want task.State
+	}{{recent, task.Scheduled}, {stale, task.Lost}} {
+		got, err := m.TaskDB.Get(tt.tk.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.State != tt.want {
+			t.Errorf("task scheduled at %s is %s, want %s", tt.tk.StateTime, got.State, tt.want)
+		}
+	}
+}
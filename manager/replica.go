@@ -0,0 +1,376 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/marktlinn/Gorcherstrator/raft"
+	"github.com/marktlinn/Gorcherstrator/scheduler"
+	"github.com/marktlinn/Gorcherstrator/store"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+const (
+	tasksStore  = "tasks"
+	eventsStore = "events"
+)
+
+// storeOp identifies a write to one of a replicated Manager's stores.
+type storeOp string
+
+const (
+	opPut    storeOp = "put"
+	opCAS    storeOp = "cas"
+	opDelete storeOp = "delete"
+)
+
+// command is a single change to a replicated Manager's state, committed through the Raft log.
+// It holds either a stateEntry or a write to the named store.
+type command struct {
+	State    *stateEntry     `json:",omitempty"`
+	Store    string          `json:",omitempty"`
+	Op       storeOp         `json:",omitempty"`
+	Key      string          `json:",omitempty"`
+	Revision uint64          `json:",omitempty"`
+	Task     *task.Task      `json:",omitempty"`
+	Event    *task.TaskEvent `json:",omitempty"`
+}
+
+// commandResult is what applying a command on the leader hands back to the code that proposed it.
+type commandResult struct {
+	Event    *task.TaskEvent
+	Revision uint64
+	Err      error
+}
+
+// replicaSnapshot is the state a replicated Manager compacts its Raft log into.
+type replicaSnapshot struct {
+	Backup    Backup
+	Revisions map[string]map[string]uint64
+}
+
+// replica is the raft.FSM of a replicated Manager. Every replica applies the same commands in the same
+// order, so their TaskDB, EventDB, Pending queue and task/worker maps stay identical.
+type replica struct {
+	m      *Manager
+	node   *raft.Node
+	tasks  *replicatedStore[*task.Task]
+	events *replicatedStore[*task.TaskEvent]
+}
+
+// NewReplicated instantiates a Manager that is one replica of a highly available cluster described by cfg.
+// Its TaskDB, EventDB and scheduling state are kept in memory and replicated to its peers through a Raft log
+// stored in cfg.DataDir, from which they are rebuilt when the Manager restarts. Only the elected leader
+// schedules work; see IsLeader.
+func NewReplicated(workers []string, schedulerType string, cfg raft.Config) *Manager {
+	m := New(workers, schedulerType, store.MEMORY)
+	if m == nil {
+		return nil
+	}
+
+	r := replica{m: m}
+	r.tasks = newReplicatedStore[*task.Task](m.TaskDB, tasksStore, r.propose,
+		func(c *command, t *task.Task) { c.Task = t })
+	r.events = newReplicatedStore[*task.TaskEvent](m.EventDB, eventsStore, r.propose,
+		func(c *command, e *task.TaskEvent) { c.Event = e })
+
+	node, err := raft.NewNode(cfg, &r)
+	if err != nil {
+		log.Printf("failed to create raft node: %s\n", err)
+		return nil
+	}
+	r.node = node
+
+	m.EventDB = &replicatedEventStore{replicatedStore: r.events, local: m.EventDB}
+	m.TaskDB = r.tasks
+	m.replica = &r
+
+	node.Start()
+	return m
+}
+
+// IsLeader reports whether the Manager should schedule work: it is either the elected leader of its
+// cluster or not replicated at all.
+func (m *Manager) IsLeader() bool {
+	return m.replica == nil || m.replica.node.IsLeader()
+}
+
+// Leader returns the address of the cluster's current leader, or an empty string if none is known.
+func (m *Manager) Leader() string {
+	if m.replica == nil {
+		return ""
+	}
+	return m.replica.node.Leader()
+}
+
+// ClusterStatus returns the Manager's view of its cluster, reporting false if it is not replicated.
+func (m *Manager) ClusterStatus() (raft.Status, bool) {
+	if m.replica == nil {
+		return raft.Status{}, false
+	}
+	return m.replica.node.Status(), true
+}
+
+// RaftHandler returns the HTTP handler serving the Manager's Raft RPCs under /raft/, or nil if the Manager
+// is not replicated.
+func (m *Manager) RaftHandler() http.Handler {
+	if m.replica == nil {
+		return nil
+	}
+	return m.replica.node.Handler()
+}
+
+// propose commits the command through the Raft log and returns the result of applying it.
+func (r *replica) propose(c command) (commandResult, error) {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return commandResult{}, fmt.Errorf("failed to marshal command: %w", err)
+	}
+
+	v, err := r.node.Apply(buf)
+	if err != nil {
+		return commandResult{}, err
+	}
+	res := v.(commandResult)
+	return res, res.Err
+}
+
+// commitState commits the stateEntry through the Raft log.
+func (r *replica) commitState(e stateEntry) (*task.TaskEvent, error) {
+	res, err := r.propose(command{State: &e})
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit %s: %w", e.Op, err)
+	}
+	return res.Event, nil
+}
+
+// Apply implements raft.FSM.
+func (r *replica) Apply(index uint64, buf []byte) any {
+	var c command
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return commandResult{Err: fmt.Errorf("failed to unmarshal command %d: %w", index, err)}
+	}
+
+	if c.State != nil {
+		r.m.mu.Lock()
+		defer r.m.mu.Unlock()
+		c.State.Seq = index
+		r.m.seq = index
+		te := r.m.apply(*c.State)
+		if rr, ok := r.m.Scheduler.(*scheduler.RoundRobin); ok && (c.State.Op == opLastWorker || c.State.Op == opRestore) {
+			rr.LastWorker = r.m.LastWorker
+		}
+		return commandResult{Event: te}
+	}
+
+	switch c.Store {
+	case tasksStore:
+		return r.tasks.apply(index, c, c.Task)
+	case eventsStore:
+		return r.events.apply(index, c, c.Event)
+	}
+	return commandResult{Err: fmt.Errorf("command %d names unknown store %q", index, c.Store)}
+}
+
+// Snapshot implements raft.FSM.
+func (r *replica) Snapshot() ([]byte, error) {
+	b, err := r.m.backup()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(replicaSnapshot{
+		Backup: b,
+		Revisions: map[string]map[string]uint64{
+			tasksStore:  r.tasks.revisions(),
+			eventsStore: r.events.revisions(),
+		},
+	})
+}
+
+// Restore implements raft.FSM.
+func (r *replica) Restore(buf []byte) error {
+	var s replicaSnapshot
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+
+	taskKey := func(t *task.Task) string { return t.ID.String() }
+	if err := r.tasks.restore(s.Backup.Tasks, taskKey, s.Revisions[tasksStore]); err != nil {
+		return err
+	}
+	eventKey := func(e *task.TaskEvent) string { return e.ID.String() }
+	if err := r.events.restore(s.Backup.Events, eventKey, s.Revisions[eventsStore]); err != nil {
+		return err
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.restore(managerState{
+		Pending:       s.Backup.Pending,
+		WorkerTaskMap: s.Backup.WorkerTaskMap,
+		TaskWorkerMap: s.Backup.TaskWorkerMap,
+		LastWorker:    s.Backup.LastWorker,
+	})
+	if rr, ok := r.m.Scheduler.(*scheduler.RoundRobin); ok {
+		rr.LastWorker = r.m.LastWorker
+	}
+	return nil
+}
+
+// replicatedStore is a Store whose writes are committed through the Raft log before being applied to a local
+// Store on every replica. Reads are served from the local Store. Revisions are the Raft log index of a key's
+// last write, so a compare-and-swap reaches the same outcome on every replica.
+type replicatedStore[T any] struct {
+	store.Store[T]
+	name     string
+	propose  func(command) (commandResult, error)
+	setValue func(*command, T)
+	watchers store.Watchers[T]
+
+	// mu guards revs, and makes a write to the local Store and its revision atomic.
+	mu   sync.RWMutex
+	revs map[string]uint64
+}
+
+func newReplicatedStore[T any](
+	local store.Store[T],
+	name string,
+	propose func(command) (commandResult, error),
+	setValue func(*command, T),
+) *replicatedStore[T] {
+	return &replicatedStore[T]{
+		Store:    local,
+		name:     name,
+		propose:  propose,
+		setValue: setValue,
+		revs:     make(map[string]uint64),
+	}
+}
+
+func (s *replicatedStore[T]) command(op storeOp, key string, revision uint64, value T) command {
+	c := command{Store: s.name, Op: op, Key: key, Revision: revision}
+	s.setValue(&c, value)
+	return c
+}
+
+func (s *replicatedStore[T]) Put(key string, value T) error {
+	_, err := s.propose(s.command(opPut, key, 0, value))
+	return err
+}
+
+func (s *replicatedStore[T]) Get(key string) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Store.Get(key)
+}
+
+func (s *replicatedStore[T]) GetWithRevision(key string) (T, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, err := s.Store.Get(key)
+	if err != nil {
+		return v, 0, err
+	}
+	return v, s.revs[key], nil
+}
+
+func (s *replicatedStore[T]) CompareAndSwap(key string, revision uint64, value T) (uint64, error) {
+	res, err := s.propose(s.command(opCAS, key, revision, value))
+	return res.Revision, err
+}
+
+func (s *replicatedStore[T]) Delete(key string) error {
+	var zero T
+	_, err := s.propose(s.command(opDelete, key, 0, zero))
+	return err
+}
+
+func (s *replicatedStore[T]) Watch(ctx context.Context) <-chan store.WatchEvent[T] {
+	return s.watchers.Watch(ctx)
+}
+
+// apply performs the write described by the command committed at index on the local Store.
+func (s *replicatedStore[T]) apply(index uint64, c command, value T) commandResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch c.Op {
+	case opCAS:
+		if s.revs[c.Key] != c.Revision {
+			return commandResult{Err: store.ErrRevisionMismatch}
+		}
+		fallthrough
+	case opPut:
+		if err := s.Store.Put(c.Key, value); err != nil {
+			return commandResult{Err: err}
+		}
+		s.revs[c.Key] = index
+		s.watchers.Notify(store.WatchEvent[T]{Op: store.OpPut, Key: c.Key, Revision: index, Value: value})
+	case opDelete:
+		removed, err := s.Store.Get(c.Key)
+		if err != nil {
+			return commandResult{Err: err}
+		}
+		if err := s.Store.Delete(c.Key); err != nil {
+			return commandResult{Err: err}
+		}
+		delete(s.revs, c.Key)
+		s.watchers.Notify(store.WatchEvent[T]{Op: store.OpDelete, Key: c.Key, Revision: index, Value: removed})
+	default:
+		return commandResult{Err: fmt.Errorf("unknown store operation %q", c.Op)}
+	}
+	return commandResult{Revision: index}
+}
+
+// revisions returns a copy of the revision of every key.
+func (s *replicatedStore[T]) revisions() map[string]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revs := make(map[string]uint64, len(s.revs))
+	for k, v := range s.revs {
+		revs[k] = v
+	}
+	return revs
+}
+
+// restore replaces the local Store's contents with the values, at the given revisions.
+func (s *replicatedStore[T]) restore(values []T, key func(T) string, revs map[string]uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.Store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", s.name, err)
+	}
+	for _, v := range current {
+		if err := s.Store.Delete(key(v)); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", s.name, err)
+		}
+	}
+
+	s.revs = make(map[string]uint64, len(values))
+	for _, v := range values {
+		k := key(v)
+		if err := s.Store.Put(k, v); err != nil {
+			return fmt.Errorf("failed to restore %s %s: %w", s.name, k, err)
+		}
+		s.revs[k] = revs[k]
+	}
+	return nil
+}
+
+// replicatedEventStore is a replicatedStore of TaskEvents, indexed by Task in its local store.
+type replicatedEventStore struct {
+	*replicatedStore[*task.TaskEvent]
+	local store.TaskEventStore
+}
+
+func (e *replicatedEventStore) ListByTask(taskID string) ([]*task.TaskEvent, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.local.ListByTask(taskID)
+}
@@ -0,0 +1,318 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/scheduler"
+	"github.com/marktlinn/Gorcherstrator/task"
+	"github.com/marktlinn/Gorcherstrator/wal"
+)
+
+const (
+	stateLogFile      = "manager.wal"
+	stateSnapshotFile = "manager.snapshot"
+)
+
+// stateOp identifies a change to the Manager's in-memory state.
+type stateOp string
+
+const (
+	opEnqueue    stateOp = "enqueue"
+	opDequeue    stateOp = "dequeue"
+	opAssign     stateOp = "assign"
+	opUnassign   stateOp = "unassign"
+	opLastWorker stateOp = "last_worker"
+	opRestore    stateOp = "restore"
+)
+
+// stateEntry is a single record of the Manager's write-ahead log.
+type stateEntry struct {
+	Seq    uint64
+	Op     stateOp
+	Event  *task.TaskEvent `json:",omitempty"`
+	TaskID uuid.UUID       `json:",omitempty"`
+	Worker string          `json:",omitempty"`
+	Index  int             `json:",omitempty"`
+	// State replaces the Manager's whole in-memory state, for opRestore.
+	State *managerState `json:",omitempty"`
+}
+
+// managerState is a point-in-time snapshot of the Manager's in-memory state.
+// Seq is the sequence number of the last stateEntry the snapshot covers.
+type managerState struct {
+	Seq           uint64
+	Pending       []task.TaskEvent
+	WorkerTaskMap map[string][]uuid.UUID
+	TaskWorkerMap map[uuid.UUID]string
+	LastWorker    int
+}
+
+// commit records the stateEntry in the write-ahead log, if the Manager has one, and applies it.
+// A stateEntry which cannot be logged is not applied, so the Manager's state never gets ahead of its log.
+// A replicated Manager instead commits the stateEntry through the Raft log, which applies it on every replica.
+func (m *Manager) commit(e stateEntry) (*task.TaskEvent, error) {
+	if m.replica != nil {
+		return m.replica.commitState(e)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.Seq = m.seq + 1
+	if m.stateLog != nil {
+		if err := m.stateLog.Append(e); err != nil {
+			return nil, fmt.Errorf("failed to append %s to the write-ahead log: %w", e.Op, err)
+		}
+	}
+	m.seq = e.Seq
+	return m.apply(e), nil
+}
+
+// apply performs the change described by the stateEntry. For opDequeue it returns the TaskEvent
+// removed from the front of the Pending queue. The caller must hold m.mu.
+func (m *Manager) apply(e stateEntry) *task.TaskEvent {
+	switch e.Op {
+	case opEnqueue:
+		m.Pending.Enqueue(*e.Event)
+	case opDequeue:
+		if m.Pending.Len() > 0 {
+			te := m.Pending.Dequeue().(task.TaskEvent)
+			return &te
+		}
+	case opAssign:
+		// A Task requeued after it was assigned may be assigned again, to the same Worker or another one, and
+		// is only ever held by the Worker it was assigned to last.
+		if w, ok := m.TaskWorkerMap[e.TaskID]; ok {
+			m.WorkerTaskMap[w] = withoutTask(m.WorkerTaskMap[w], e.TaskID)
+		}
+		m.WorkerTaskMap[e.Worker] = append(withoutTask(m.WorkerTaskMap[e.Worker], e.TaskID), e.TaskID)
+		m.TaskWorkerMap[e.TaskID] = e.Worker
+	case opUnassign:
+		if w, ok := m.TaskWorkerMap[e.TaskID]; ok {
+			delete(m.TaskWorkerMap, e.TaskID)
+			m.WorkerTaskMap[w] = withoutTask(m.WorkerTaskMap[w], e.TaskID)
+		}
+	case opLastWorker:
+		m.LastWorker = e.Index
+	case opRestore:
+		seq := m.seq
+		m.restore(*e.State)
+		m.seq = seq
+	}
+	return nil
+}
+
+// withoutTask returns a copy of the Task IDs without the given Task's.
+func withoutTask(ids []uuid.UUID, taskID uuid.UUID) []uuid.UUID {
+	kept := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if id != taskID {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// enqueue adds the TaskEvent to the back of the Pending queue.
+func (m *Manager) enqueue(te task.TaskEvent) error {
+	_, err := m.commit(stateEntry{Op: opEnqueue, Event: &te})
+	if err != nil {
+		log.Printf("failed to enqueue task event %s: %s\n", te.ID, err)
+	}
+	return err
+}
+
+// peek returns the TaskEvent at the front of the Pending queue without removing it, reporting false if the
+// queue is empty.
+func (m *Manager) peek() (task.TaskEvent, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Pending.Len() == 0 {
+		return task.TaskEvent{}, false
+	}
+	return m.Pending.Peek().(task.TaskEvent), true
+}
+
+// isPending reports whether a TaskEvent for the Task is in the Pending queue.
+func (m *Manager) isPending(taskID uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The queue cannot be iterated, so it is cycled through once, as in snapshot.
+	pending := false
+	for i := m.Pending.Len(); i > 0; i-- {
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		pending = pending || te.Task.ID == taskID
+		m.Pending.Enqueue(te)
+	}
+	return pending
+}
+
+// dequeue removes the TaskEvent at the front of the Pending queue.
+func (m *Manager) dequeue() {
+	if _, err := m.commit(stateEntry{Op: opDequeue}); err != nil {
+		log.Printf("failed to dequeue task event: %s\n", err)
+	}
+}
+
+// assign records that the Task has been scheduled onto the Worker, which becomes the LastWorker.
+func (m *Manager) assign(taskID uuid.UUID, worker string) {
+	if _, err := m.commit(stateEntry{Op: opAssign, TaskID: taskID, Worker: worker}); err != nil {
+		log.Printf("failed to assign task %s to worker %s: %s\n", taskID, worker, err)
+		return
+	}
+	for i, w := range m.Workers {
+		if w == worker {
+			if _, err := m.commit(stateEntry{Op: opLastWorker, Index: i}); err != nil {
+				log.Printf("failed to record last worker %s: %s\n", worker, err)
+			}
+		}
+	}
+}
+
+// unassign forgets which Worker the Task was scheduled onto.
+func (m *Manager) unassign(taskID uuid.UUID) {
+	if _, ok := m.workerFor(taskID); ok {
+		if _, err := m.commit(stateEntry{Op: opUnassign, TaskID: taskID}); err != nil {
+			log.Printf("failed to unassign task %s: %s\n", taskID, err)
+		}
+	}
+}
+
+// workerFor returns the Worker the Task was scheduled onto.
+func (m *Manager) workerFor(taskID uuid.UUID) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.TaskWorkerMap[taskID]
+	return w, ok
+}
+
+// snapshot captures the Manager's in-memory state. The caller must hold m.mu.
+func (m *Manager) snapshot() managerState {
+	state := managerState{
+		Seq:           m.seq,
+		WorkerTaskMap: make(map[string][]uuid.UUID, len(m.WorkerTaskMap)),
+		TaskWorkerMap: make(map[uuid.UUID]string, len(m.TaskWorkerMap)),
+		LastWorker:    m.LastWorker,
+	}
+
+	// The queue cannot be iterated, so it is cycled through once to copy each TaskEvent.
+	for i := m.Pending.Len(); i > 0; i-- {
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		state.Pending = append(state.Pending, te)
+		m.Pending.Enqueue(te)
+	}
+	for w, ids := range m.WorkerTaskMap {
+		state.WorkerTaskMap[w] = append([]uuid.UUID(nil), ids...)
+	}
+	for id, w := range m.TaskWorkerMap {
+		state.TaskWorkerMap[id] = w
+	}
+	return state
+}
+
+// restore replaces the Manager's in-memory state with the snapshot. The caller must hold m.mu.
+func (m *Manager) restore(state managerState) {
+	for m.Pending.Len() > 0 {
+		m.Pending.Dequeue()
+	}
+	for _, te := range state.Pending {
+		m.Pending.Enqueue(te)
+	}
+	for w := range m.WorkerTaskMap {
+		m.WorkerTaskMap[w] = []uuid.UUID{}
+	}
+	clear(m.TaskWorkerMap)
+	for w, ids := range state.WorkerTaskMap {
+		m.WorkerTaskMap[w] = ids
+	}
+	for id, w := range state.TaskWorkerMap {
+		m.TaskWorkerMap[id] = w
+	}
+	m.LastWorker = state.LastWorker
+	m.seq = state.Seq
+}
+
+// recoverState opens the Manager's write-ahead log and rebuilds its in-memory state from the latest
+// snapshot followed by every logged stateEntry the snapshot does not cover.
+func (m *Manager) recoverState() error {
+	var state managerState
+	found, err := wal.ReadSnapshot(stateSnapshotFile, &state)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if found {
+		m.restore(state)
+	}
+
+	l, err := wal.Open(stateLogFile)
+	if err != nil {
+		return err
+	}
+
+	replayed := 0
+	err = l.Replay(func(record json.RawMessage) error {
+		var e stateEntry
+		if err := json.Unmarshal(record, &e); err != nil {
+			return fmt.Errorf("failed to unmarshal write-ahead log entry: %w", err)
+		}
+		if e.Seq <= m.seq {
+			return nil
+		}
+		m.apply(e)
+		m.seq = e.Seq
+		replayed++
+		return nil
+	})
+	if err != nil {
+		l.Close()
+		return err
+	}
+	m.stateLog = l
+
+	if rr, ok := m.Scheduler.(*scheduler.RoundRobin); ok {
+		rr.LastWorker = m.LastWorker
+	}
+	log.Printf("recovered manager state: %d pending, %d assigned tasks, %d log entries replayed\n",
+		m.Pending.Len(), len(m.TaskWorkerMap), replayed)
+	return nil
+}
+
+// SnapshotState periodically writes a snapshot of the Manager's in-memory state and truncates
+// the write-ahead log the snapshot now covers.
+func (m *Manager) SnapshotState() {
+	if m.replica != nil {
+		log.Println("manager state is replicated; snapshots are taken by the raft log")
+		return
+	}
+	if m.stateLog == nil {
+		log.Println("manager state is not persisted; snapshots disabled")
+		return
+	}
+
+	var rest time.Duration = 60
+	for {
+		time.Sleep(rest * time.Second)
+		if err := m.writeSnapshot(); err != nil {
+			log.Printf("failed to snapshot manager state: %s\n", err)
+			continue
+		}
+		log.Printf("Manager state snapshotted; next snapshot in %d seconds\n", rest)
+	}
+}
+
+// writeSnapshot writes a snapshot of the Manager's state and truncates the write-ahead log.
+func (m *Manager) writeSnapshot() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := wal.WriteSnapshot(stateSnapshotFile, m.snapshot()); err != nil {
+		return err
+	}
+	return m.stateLog.Truncate()
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/scheduler"
+	"github.com/marktlinn/Gorcherstrator/store"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// newTestManager creates a Manager with in-memory stores for the named Workers.
+func newTestManager(t *testing.T, workers ...string) *Manager {
+	t.Helper()
+	m := New(workers, scheduler.ROUND_ROBIN, store.MEMORY)
+	if m == nil {
+		t.Fatal("failed to create manager")
+	}
+	return m
+}
+
+// checkAssignments fails the test unless WorkerTaskMap and TaskWorkerMap hold the same assignments.
+func checkAssignments(t *testing.T, m *Manager) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assigned := 0
+	for w, ids := range m.WorkerTaskMap {
+		seen := make(map[uuid.UUID]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				t.Errorf("task %s is held by worker %s twice", id, w)
+			}
+			seen[id] = true
+			if m.TaskWorkerMap[id] != w {
+				t.Errorf("task %s is held by worker %s but assigned to worker %q", id, w, m.TaskWorkerMap[id])
+			}
+		}
+		assigned += len(ids)
+	}
+	if assigned != len(m.TaskWorkerMap) {
+		t.Errorf("workers hold %d tasks, but %d are assigned", assigned, len(m.TaskWorkerMap))
+	}
+}
+
+func TestRequeuedTaskMovesWorker(t *testing.T) {
+	// Neither Worker accepts connections, so the Task is requeued each time it is sent.
+	m := newTestManager(t, "127.0.0.1:1", "127.0.0.1:2")
+	id := uuid.New()
+	if err := m.AddTask(task.TaskEvent{
+		ID:    uuid.New(),
+		State: task.Scheduled,
+		Task:  task.Task{ID: id, Name: "web", Image: "nginx"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	workers := make(map[string]bool)
+	for range 3 {
+		m.SendWork()
+		w, ok := m.workerFor(id)
+		if !ok {
+			t.Fatal("requeued task is not assigned to a worker")
+		}
+		workers[w] = true
+		checkAssignments(t, m)
+	}
+	if len(workers) != 2 {
+		t.Errorf("requeued task was assigned to %d workers, want 2", len(workers))
+	}
+}
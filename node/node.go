@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
 	"github.com/marktlinn/Gorcherstrator/stats"
 	"github.com/marktlinn/Gorcherstrator/utils"
@@ -24,6 +25,14 @@ type Node struct {
 	TaskCount       int
 	Api             string
 	Stats           stats.Stats
+	// PortRange is the range dynamic host ports are allocated from; DefaultPortRange is used when unset.
+	PortRange PortRange
+
+	// mu guards Disk, DiskAllocated and the Node's allocations: ports maps each allocated host port, as
+	// "port/proto", to the Task holding it, and disk maps each Task to the bytes of disk allocated to it.
+	mu    sync.Mutex
+	ports map[string]string
+	disk  map[string]int64
 }
 
 // NewNode returns a reference to a new Node entity.
@@ -71,9 +80,56 @@ func (n *Node) GetStats() (*stats.Stats, error) {
 		return nil, fmt.Errorf("error getting stats from node %s", n.Name)
 	}
 
+	n.mu.Lock()
 	n.Disk = int64(status.DiskTotal())
+	n.mu.Unlock()
 	n.Memory = int64(status.MemTotalKB())
 	n.Stats = status
 
 	return &n.Stats, nil
 }
+
+// AllocateDisk records that the owner needs the given bytes of disk on the Node, replacing whatever it needed
+// before, and adds them to the Node's DiskAllocated.
+func (n *Node) AllocateDisk(owner string, bytes int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.DiskAllocated -= n.disk[owner]
+	if bytes <= 0 {
+		delete(n.disk, owner)
+		return
+	}
+	if n.disk == nil {
+		n.disk = make(map[string]int64)
+	}
+	n.disk[owner] = bytes
+	n.DiskAllocated += bytes
+}
+
+// DiskAvailable returns the bytes of the Node's disk not yet allocated.
+func (n *Node) DiskAvailable() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.Disk - n.DiskAllocated
+}
+
+// Release frees the host ports and disk allocated to the owner on the Node.
+func (n *Node) Release(owner string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.releasePorts(owner)
+	n.DiskAllocated -= n.disk[owner]
+	delete(n.disk, owner)
+}
+
+// Reset frees everything allocated on the Node.
+func (n *Node) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ports = nil
+	n.disk = nil
+	n.DiskAllocated = 0
+}
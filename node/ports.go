@@ -0,0 +1,130 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// PortRange is an inclusive range of host ports.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// DefaultPortRange is the range dynamic host ports are allocated from unless a Node is configured otherwise.
+// It sits just below the range Docker publishes ephemeral ports on, so the two never collide.
+var DefaultPortRange = PortRange{Min: 30000, Max: 32767}
+
+// ParsePortRange parses a PortRange written as "min-max", e.g. "30000-32767".
+func ParsePortRange(s string) (PortRange, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return PortRange{}, fmt.Errorf("invalid port range %q: expected min-max", s)
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return PortRange{Min: min, Max: max}, nil
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// CanAllocatePorts reports whether AllocatePorts would succeed for the owner and requests.
+func (n *Node) CanAllocatePorts(owner string, reqs map[nat.Port]string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err := n.resolvePorts(owner, reqs)
+	return err == nil
+}
+
+// AllocatePorts reserves a host port on the Node for each of the owner's requests, which map a container port
+// to the host port it needs, or to an empty string for any free port from the Node's PortRange. It returns the
+// host port reserved for each container port, replacing whatever the owner held before. A host port is never
+// reserved for two owners at once.
+func (n *Node) AllocatePorts(owner string, reqs map[nat.Port]string) (map[nat.Port]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resolved, err := n.resolvePorts(owner, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	n.releasePorts(owner)
+	if n.ports == nil {
+		n.ports = make(map[string]string)
+	}
+	for p, hostPort := range resolved {
+		n.ports[hostPort+"/"+p.Proto()] = owner
+	}
+	return resolved, nil
+}
+
+// releasePorts frees the owner's host ports. The caller must hold n.mu.
+func (n *Node) releasePorts(owner string) {
+	for p, o := range n.ports {
+		if o == owner {
+			delete(n.ports, p)
+		}
+	}
+}
+
+// resolvePorts picks a host port for each request without reserving any. Host ports held by the owner are
+// considered free. The caller must hold n.mu.
+func (n *Node) resolvePorts(owner string, reqs map[nat.Port]string) (map[nat.Port]string, error) {
+	claimed := make(map[string]bool)
+	free := func(key string) bool {
+		o, taken := n.ports[key]
+		return !claimed[key] && (!taken || o == owner)
+	}
+
+	resolved := make(map[nat.Port]string, len(reqs))
+	var dynamic []nat.Port
+	for p, hostPort := range reqs {
+		if hostPort == "" {
+			dynamic = append(dynamic, p)
+			continue
+		}
+		key := hostPort + "/" + p.Proto()
+		if !free(key) {
+			return nil, fmt.Errorf("host port %s is already allocated on node %s", key, n.Name)
+		}
+		claimed[key] = true
+		resolved[p] = hostPort
+	}
+
+	// Dynamic ports are handed out lowest first, in container port order, so allocations are predictable.
+	sort.Slice(dynamic, func(i, j int) bool { return dynamic[i] < dynamic[j] })
+	r := n.PortRange
+	if r == (PortRange{}) {
+		r = DefaultPortRange
+	}
+	for _, p := range dynamic {
+		for port := r.Min; port <= r.Max; port++ {
+			key := fmt.Sprintf("%d/%s", port, p.Proto())
+			if free(key) {
+				claimed[key] = true
+				resolved[p] = strconv.Itoa(port)
+				break
+			}
+		}
+		if _, ok := resolved[p]; !ok {
+			return nil, fmt.Errorf("no free host port in range %s on node %s", r, n.Name)
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,786 @@
+// Package raft implements the Raft consensus algorithm over HTTP, replicating an ordered log of opaque
+// commands to a fixed set of peers and applying committed commands to a state machine.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotLeader      = errors.New("raft: node is not the leader")
+	ErrLeadershipLost = errors.New("raft: leadership lost before the command was committed")
+	ErrTimeout        = errors.New("raft: timed out waiting for the command to be applied")
+)
+
+const (
+	defaultHeartbeatInterval = 100 * time.Millisecond
+	defaultElectionTimeout   = time.Second
+	defaultApplyTimeout      = 5 * time.Second
+	defaultSnapshotThreshold = 1024
+	maxAppendEntries         = 256
+	tickInterval             = 20 * time.Millisecond
+)
+
+// FSM is the state machine the replicated log is applied to. Apply, Snapshot and Restore are never called
+// concurrently.
+type FSM interface {
+	// Apply applies the command committed at index; its return value is handed back to the caller of
+	// Node.Apply. The index is the same on every node, so it may be used to version the state it changes.
+	Apply(index uint64, command []byte) any
+	// Snapshot serialises the state machine, covering every command applied so far.
+	Snapshot() ([]byte, error)
+	// Restore replaces the state machine's contents with a snapshot. A failed Restore must leave the state
+	// machine as it was, as the snapshot is retried later.
+	Restore(snapshot []byte) error
+}
+
+// Config describes a Node and the cluster it belongs to.
+type Config struct {
+	// ID is the host:port the Node's Handler is served on; it doubles as its identity.
+	ID string
+	// Peers lists the ID of every node in the cluster, including this one.
+	Peers   []string
+	DataDir string
+	// Secret is shared by every node in the cluster; RPCs not carrying it are refused.
+	Secret string
+
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration
+	ApplyTimeout      time.Duration
+	// SnapshotThreshold is the number of applied entries after which the log is compacted into a snapshot.
+	SnapshotThreshold uint64
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+var roleNames = map[role]string{
+	follower:  "follower",
+	candidate: "candidate",
+	leader:    "leader",
+}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+// Status describes a Node's view of the cluster.
+type Status struct {
+	ID          string
+	Role        string
+	Leader      string
+	Term        uint64
+	CommitIndex uint64
+	LastApplied uint64
+}
+
+type result struct {
+	value any
+	err   error
+}
+
+// Node is a single member of a Raft cluster.
+type Node struct {
+	cfg     Config
+	fsm     FSM
+	storage *storage
+	client  *http.Client
+
+	// applyMu serialises calls into the FSM; it is always acquired before mu.
+	applyMu sync.Mutex
+	mu      sync.Mutex
+
+	role     role
+	term     uint64
+	votedFor string
+	leader   string
+
+	// log holds the entries after snapshotIndex.
+	log           []Entry
+	snapshotIndex uint64
+	snapshotTerm  uint64
+	snapshot      []byte
+
+	commitIndex uint64
+	lastApplied uint64
+
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	inflight   map[string]bool
+	waiters    map[uint64]chan result
+
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+
+	applyCh     chan struct{}
+	replicateCh chan struct{}
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewNode opens the Node's storage under cfg.DataDir and restores the FSM from the latest snapshot. Call Start
+// to join the cluster.
+func NewNode(cfg Config, fsm FSM) (*Node, error) {
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if cfg.ElectionTimeout == 0 {
+		cfg.ElectionTimeout = defaultElectionTimeout
+	}
+	if cfg.ApplyTimeout == 0 {
+		cfg.ApplyTimeout = defaultApplyTimeout
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = defaultSnapshotThreshold
+	}
+
+	member := false
+	for _, p := range cfg.Peers {
+		if p == cfg.ID {
+			member = true
+		}
+	}
+	if !member {
+		return nil, fmt.Errorf("raft: node %s is not in its own peer list %v", cfg.ID, cfg.Peers)
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("raft: a shared secret is required to authenticate the cluster's RPCs")
+	}
+
+	s, err := openStorage(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := s.load()
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to load raft state: %w", err)
+	}
+
+	if ps.snapshot != nil {
+		if err := fsm.Restore(ps.snapshot); err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to restore raft snapshot: %w", err)
+		}
+	}
+
+	n := Node{
+		cfg:     cfg,
+		fsm:     fsm,
+		storage: s,
+		client:  &http.Client{Timeout: 2 * cfg.ElectionTimeout},
+
+		term:          ps.term,
+		votedFor:      ps.votedFor,
+		log:           ps.entries,
+		snapshotIndex: ps.snapshotIndex,
+		snapshotTerm:  ps.snapshotTerm,
+		snapshot:      ps.snapshot,
+		commitIndex:   ps.snapshotIndex,
+		lastApplied:   ps.snapshotIndex,
+
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		inflight:   make(map[string]bool),
+		waiters:    make(map[uint64]chan result),
+
+		applyCh:     make(chan struct{}, 1),
+		replicateCh: make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	n.resetElectionDeadline()
+	return &n, nil
+}
+
+// Start runs the Node's election, replication and apply loops in the background.
+func (n *Node) Start() {
+	go n.run()
+	go n.applyCommitted()
+}
+
+// Stop halts the Node's loops and closes its storage.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stopCh)
+		n.mu.Lock()
+		n.stepDown(n.term)
+		n.mu.Unlock()
+		n.applyMu.Lock()
+		defer n.applyMu.Unlock()
+		n.storage.close()
+	})
+}
+
+// Apply replicates command to the cluster and returns the FSM's result once it has been committed and applied
+// on this node. Only the leader accepts commands.
+func (n *Node) Apply(command []byte) (any, error) {
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Command: command}
+	if err := n.storage.append([]Entry{e}); err != nil {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("failed to append to raft log: %w", err)
+	}
+	n.log = append(n.log, e)
+
+	ch := make(chan result, 1)
+	n.waiters[e.Index] = ch
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	n.notify(n.replicateCh)
+
+	select {
+	case r := <-ch:
+		return r.value, r.err
+	case <-time.After(n.cfg.ApplyTimeout):
+		n.mu.Lock()
+		delete(n.waiters, e.Index)
+		n.mu.Unlock()
+		return nil, ErrTimeout
+	}
+}
+
+// IsLeader reports whether the Node currently believes it is the cluster leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader
+}
+
+// Leader returns the ID of the current leader, or an empty string if none is known.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Status returns the Node's current view of the cluster.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:          n.cfg.ID,
+		Role:        n.role.String(),
+		Leader:      n.leader,
+		Term:        n.term,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+	}
+}
+
+func (n *Node) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.replicateCh:
+			n.mu.Lock()
+			if n.role == leader {
+				n.broadcast()
+			}
+			n.mu.Unlock()
+		case now := <-ticker.C:
+			n.mu.Lock()
+			switch {
+			case n.role == leader && now.Sub(n.lastHeartbeat) >= n.cfg.HeartbeatInterval:
+				n.broadcast()
+			case n.role != leader && now.After(n.electionDeadline):
+				n.startElection()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// notify signals ch without blocking.
+func (n *Node) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) resetElectionDeadline() {
+	jitter := time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(n.cfg.ElectionTimeout + jitter)
+}
+
+func (n *Node) quorum() int {
+	return len(n.cfg.Peers)/2 + 1
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.snapshotIndex + uint64(len(n.log))
+}
+
+func (n *Node) lastTerm() uint64 {
+	if len(n.log) == 0 {
+		return n.snapshotTerm
+	}
+	return n.log[len(n.log)-1].Term
+}
+
+// termAt returns the term of the entry at index, and false if the entry is not held.
+func (n *Node) termAt(index uint64) (uint64, bool) {
+	if index == n.snapshotIndex {
+		return n.snapshotTerm, true
+	}
+	if index < n.snapshotIndex || index > n.lastIndex() {
+		return 0, false
+	}
+	return n.log[index-n.snapshotIndex-1].Term, true
+}
+
+// setTerm durably moves the Node to term with the given vote.
+func (n *Node) setTerm(term uint64, votedFor string) {
+	if err := n.storage.setTermAndVote(term, votedFor); err != nil {
+		log.Printf("failed to persist raft term %d: %v\n", term, err)
+	}
+	n.term = term
+	n.votedFor = votedFor
+}
+
+// stepDown turns the Node into a follower of term, failing any commands still waiting to be applied.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.setTerm(term, "")
+		n.leader = ""
+	}
+	if n.role == leader {
+		log.Printf("raft: %s stepping down in term %d\n", n.cfg.ID, n.term)
+	}
+	n.role = follower
+	n.resetElectionDeadline()
+
+	for index, ch := range n.waiters {
+		ch <- result{err: ErrLeadershipLost}
+		delete(n.waiters, index)
+	}
+}
+
+func (n *Node) startElection() {
+	n.role = candidate
+	n.leader = ""
+	n.setTerm(n.term+1, n.cfg.ID)
+	n.resetElectionDeadline()
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+
+	req := VoteRequest{
+		Term:         n.term,
+		CandidateID:  n.cfg.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.ID {
+			continue
+		}
+
+		go func(peer string) {
+			var resp VoteResponse
+			if err := n.call(peer, "vote", req, &resp); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if n.role != candidate || n.term != req.Term || !resp.VoteGranted {
+				return
+			}
+
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeader() {
+	log.Printf("raft: %s elected leader in term %d\n", n.cfg.ID, n.term)
+	n.role = leader
+	n.leader = n.cfg.ID
+	for _, peer := range n.cfg.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+
+	// A leader may only count replicas of entries from its own term, so commit a no-op to carry forward
+	// whatever earlier terms left behind.
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term}
+	if err := n.storage.append([]Entry{e}); err != nil {
+		log.Printf("failed to append raft no-op entry: %v\n", err)
+		n.stepDown(n.term)
+		return
+	}
+	n.log = append(n.log, e)
+	n.advanceCommit()
+	n.broadcast()
+}
+
+// broadcast sends entries, or a heartbeat, to every peer without a request already in flight.
+func (n *Node) broadcast() {
+	n.lastHeartbeat = time.Now()
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.ID || n.inflight[peer] {
+			continue
+		}
+		n.inflight[peer] = true
+		go n.replicate(peer)
+	}
+}
+
+// replicate brings peer's log up to date with the leader's, stopping once it has caught up, leadership is
+// lost or the peer cannot be reached.
+func (n *Node) replicate(peer string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	defer func() { n.inflight[peer] = false }()
+
+	for n.role == leader {
+		term := n.term
+		next := n.nextIndex[peer]
+
+		if next <= n.snapshotIndex {
+			req := SnapshotRequest{
+				Term:              term,
+				LeaderID:          n.cfg.ID,
+				LastIncludedIndex: n.snapshotIndex,
+				LastIncludedTerm:  n.snapshotTerm,
+				Data:              n.snapshot,
+			}
+
+			n.mu.Unlock()
+			var resp SnapshotResponse
+			err := n.call(peer, "snapshot", req, &resp)
+			n.mu.Lock()
+
+			if err != nil || n.role != leader || n.term != term {
+				return
+			}
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if !resp.Success {
+				return
+			}
+			n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIncludedIndex)
+			n.nextIndex[peer] = req.LastIncludedIndex + 1
+			continue
+		}
+
+		prevTerm, _ := n.termAt(next - 1)
+		count := min(int(n.lastIndex()-next+1), maxAppendEntries)
+		entries := make([]Entry, count)
+		copy(entries, n.log[next-n.snapshotIndex-1:])
+
+		req := AppendRequest{
+			Term:         term,
+			LeaderID:     n.cfg.ID,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  prevTerm,
+			Entries:      entries,
+			LeaderCommit: n.commitIndex,
+		}
+
+		n.mu.Unlock()
+		var resp AppendResponse
+		err := n.call(peer, "append", req, &resp)
+		n.mu.Lock()
+
+		if err != nil || n.role != leader || n.term != term {
+			return
+		}
+		if resp.Term > n.term {
+			n.stepDown(resp.Term)
+			return
+		}
+
+		if resp.Success {
+			match := req.PrevLogIndex + uint64(len(entries))
+			n.matchIndex[peer] = max(n.matchIndex[peer], match)
+			n.nextIndex[peer] = match + 1
+			n.advanceCommit()
+			if n.nextIndex[peer] > n.lastIndex() {
+				return
+			}
+			continue
+		}
+
+		if resp.ConflictIndex > 0 && resp.ConflictIndex < next {
+			n.nextIndex[peer] = resp.ConflictIndex
+		} else {
+			n.nextIndex[peer] = max(next-1, 1)
+		}
+	}
+}
+
+// advanceCommit moves the leader's commit index to the highest entry of its term held by a quorum.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.termAt(index); term != n.term {
+			break
+		}
+
+		replicas := 1
+		for _, peer := range n.cfg.Peers {
+			if peer != n.cfg.ID && n.matchIndex[peer] >= index {
+				replicas++
+			}
+		}
+		if replicas >= n.quorum() {
+			n.commitIndex = index
+			n.notify(n.applyCh)
+			return
+		}
+	}
+}
+
+func (n *Node) handleVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+	}
+	if req.Term < n.term {
+		return VoteResponse{Term: n.term}
+	}
+
+	upToDate := req.LastLogTerm > n.lastTerm() ||
+		(req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.setTerm(n.term, req.CandidateID)
+		n.resetElectionDeadline()
+		return VoteResponse{Term: n.term, VoteGranted: true}
+	}
+	return VoteResponse{Term: n.term}
+}
+
+func (n *Node) handleAppend(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return AppendResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.LeaderID
+	n.resetElectionDeadline()
+
+	// Entries already covered by our snapshot are committed and so must match the leader's.
+	entries := req.Entries
+	prevIndex, prevTerm := req.PrevLogIndex, req.PrevLogTerm
+	if prevIndex < n.snapshotIndex {
+		skip := min(n.snapshotIndex-prevIndex, uint64(len(entries)))
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.snapshotIndex, n.snapshotTerm
+	}
+
+	if prevIndex > n.lastIndex() {
+		return AppendResponse{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+	}
+	if term, _ := n.termAt(prevIndex); term != prevTerm {
+		conflict := prevIndex
+		for conflict > n.snapshotIndex+1 {
+			if t, _ := n.termAt(conflict - 1); t != term {
+				break
+			}
+			conflict--
+		}
+		return AppendResponse{Term: n.term, ConflictIndex: conflict}
+	}
+
+	for i, e := range entries {
+		term, ok := n.termAt(e.Index)
+		if ok && term == e.Term {
+			continue
+		}
+		if ok {
+			n.truncateFrom(e.Index)
+		}
+		if err := n.storage.append(entries[i:]); err != nil {
+			log.Printf("failed to append raft entries: %v\n", err)
+			return AppendResponse{Term: n.term, ConflictIndex: e.Index}
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = min(req.LeaderCommit, prevIndex+uint64(len(entries)))
+		n.notify(n.applyCh)
+	}
+	return AppendResponse{Term: n.term, Success: true}
+}
+
+// truncateFrom discards the entries at and after index, which conflict with the leader's log.
+func (n *Node) truncateFrom(index uint64) {
+	if err := n.storage.truncateFrom(index); err != nil {
+		log.Printf("failed to truncate raft log at %d: %v\n", index, err)
+	}
+	n.log = n.log[:index-n.snapshotIndex-1]
+
+	for i, ch := range n.waiters {
+		if i >= index {
+			ch <- result{err: ErrLeadershipLost}
+			delete(n.waiters, i)
+		}
+	}
+}
+
+func (n *Node) handleSnapshot(req SnapshotRequest) SnapshotResponse {
+	n.mu.Lock()
+	if req.Term < n.term {
+		defer n.mu.Unlock()
+		return SnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.LeaderID
+	n.resetElectionDeadline()
+	n.mu.Unlock()
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.LastIncludedIndex <= n.lastApplied {
+		return SnapshotResponse{Term: n.term, Success: true}
+	}
+
+	// Nothing changes unless the FSM takes the snapshot, so the leader sends it again.
+	if err := n.fsm.Restore(req.Data); err != nil {
+		log.Printf("failed to restore raft snapshot: %v\n", err)
+		return SnapshotResponse{Term: n.term}
+	}
+
+	// Keep any entries following the snapshot if our log agrees with it, otherwise the whole log is stale.
+	term, ok := n.termAt(req.LastIncludedIndex)
+	keep := ok && term == req.LastIncludedTerm
+	if err := n.storage.saveSnapshot(req.LastIncludedIndex, req.LastIncludedTerm, req.Data, !keep); err != nil {
+		// The FSM already holds the snapshot, so carry on from it; storage catches up at the next compaction.
+		log.Printf("failed to save raft snapshot: %v\n", err)
+	}
+	if keep {
+		n.log = append([]Entry(nil), n.log[req.LastIncludedIndex-n.snapshotIndex:]...)
+	} else {
+		n.log = nil
+	}
+
+	n.snapshotIndex = req.LastIncludedIndex
+	n.snapshotTerm = req.LastIncludedTerm
+	n.snapshot = req.Data
+	n.commitIndex = max(n.commitIndex, req.LastIncludedIndex)
+	n.lastApplied = req.LastIncludedIndex
+	return SnapshotResponse{Term: n.term, Success: true}
+}
+
+// applyCommitted applies committed entries to the FSM in order, compacting the log as it grows.
+func (n *Node) applyCommitted() {
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyCh:
+		}
+
+		for n.applyNext() {
+		}
+		n.compact()
+	}
+}
+
+// applyNext applies the entry after lastApplied if it is committed, reporting whether it did so.
+func (n *Node) applyNext() bool {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.lastApplied >= n.commitIndex {
+		n.mu.Unlock()
+		return false
+	}
+	e := n.log[n.lastApplied-n.snapshotIndex]
+	n.mu.Unlock()
+
+	var value any
+	if e.Command != nil {
+		value = n.fsm.Apply(e.Index, e.Command)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lastApplied = e.Index
+	if ch, ok := n.waiters[e.Index]; ok {
+		ch <- result{value: value}
+		delete(n.waiters, e.Index)
+	}
+	return true
+}
+
+// compact snapshots the FSM and discards the log entries it covers once enough have been applied.
+func (n *Node) compact() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.lastApplied-n.snapshotIndex < n.cfg.SnapshotThreshold {
+		n.mu.Unlock()
+		return
+	}
+	index := n.lastApplied
+	term, _ := n.termAt(index)
+	n.mu.Unlock()
+
+	data, err := n.fsm.Snapshot()
+	if err != nil {
+		log.Printf("failed to snapshot raft state machine: %v\n", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.storage.saveSnapshot(index, term, data, false); err != nil {
+		log.Printf("failed to save raft snapshot: %v\n", err)
+		return
+	}
+	n.log = append([]Entry(nil), n.log[index-n.snapshotIndex:]...)
+	n.snapshotIndex = index
+	n.snapshotTerm = term
+	n.snapshot = data
+}
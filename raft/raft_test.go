@@ -0,0 +1,271 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+// listFSM records the commands applied to it in order.
+type listFSM struct {
+	mu       sync.Mutex
+	commands []string
+	// failRestores is the number of calls to Restore that fail before one succeeds.
+	failRestores int
+	restores     int
+}
+
+func (f *listFSM) Apply(index uint64, command []byte) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, string(command))
+	return len(f.commands)
+}
+
+func (f *listFSM) Snapshot() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Marshal(f.commands)
+}
+
+func (f *listFSM) Restore(snapshot []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failRestores > 0 {
+		f.failRestores--
+		return errors.New("restore failed")
+	}
+	var commands []string
+	if err := json.Unmarshal(snapshot, &commands); err != nil {
+		return err
+	}
+	f.commands = commands
+	f.restores++
+	return nil
+}
+
+func (f *listFSM) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.commands)
+}
+
+// testNode is a Node served on its own localhost listener.
+type testNode struct {
+	*Node
+	fsm    *listFSM
+	server *http.Server
+	ln     net.Listener
+}
+
+// serve starts the Node and its server.
+func (tn *testNode) serve() {
+	tn.Start()
+	go tn.server.Serve(tn.ln)
+}
+
+// stop stops the Node and its server, as if its process died.
+func (tn *testNode) stop() {
+	tn.server.Close()
+	tn.ln.Close()
+	tn.Stop()
+}
+
+// newCluster creates, but does not start, size Nodes with peers on localhost.
+func newCluster(t *testing.T, size int, snapshotThreshold uint64) []*testNode {
+	t.Helper()
+
+	listeners := make([]net.Listener, size)
+	peers := make([]string, size)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = ln
+		peers[i] = ln.Addr().String()
+	}
+
+	nodes := make([]*testNode, size)
+	for i, ln := range listeners {
+		fsm := &listFSM{}
+		n, err := NewNode(Config{
+			ID:                peers[i],
+			Peers:             peers,
+			DataDir:           t.TempDir(),
+			Secret:            testSecret,
+			HeartbeatInterval: 20 * time.Millisecond,
+			ElectionTimeout:   150 * time.Millisecond,
+			SnapshotThreshold: snapshotThreshold,
+		}, fsm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/raft/", n.Handler())
+		tn := &testNode{Node: n, fsm: fsm, server: &http.Server{Handler: mux}, ln: ln}
+		nodes[i] = tn
+		t.Cleanup(tn.stop)
+	}
+	return nodes
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForLeader waits until exactly one of the nodes leads and every other node follows it.
+func waitForLeader(t *testing.T, nodes []*testNode) *testNode {
+	t.Helper()
+	var leader *testNode
+	waitFor(t, "a leader", func() bool {
+		leader = nil
+		for _, n := range nodes {
+			if n.IsLeader() {
+				if leader != nil {
+					return false
+				}
+				leader = n
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		for _, n := range nodes {
+			if n.Leader() != leader.cfg.ID {
+				return false
+			}
+		}
+		return true
+	})
+	return leader
+}
+
+func apply(t *testing.T, n *testNode, commands ...string) {
+	t.Helper()
+	for _, c := range commands {
+		if _, err := n.Apply([]byte(c)); err != nil {
+			t.Fatalf("failed to apply %q: %v", c, err)
+		}
+	}
+}
+
+func commands(count int) []string {
+	cmds := make([]string, count)
+	for i := range cmds {
+		cmds[i] = fmt.Sprintf("cmd-%d", i)
+	}
+	return cmds
+}
+
+func TestElection(t *testing.T) {
+	nodes := newCluster(t, 3, 0)
+	for _, n := range nodes {
+		n.serve()
+	}
+
+	leader := waitForLeader(t, nodes)
+	term := leader.Status().Term
+
+	leader.stop()
+	var rest []*testNode
+	for _, n := range nodes {
+		if n != leader {
+			rest = append(rest, n)
+		}
+	}
+
+	next := waitForLeader(t, rest)
+	if next.Status().Term <= term {
+		t.Errorf("new leader elected in term %d, want a term after %d", next.Status().Term, term)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	nodes := newCluster(t, 3, 0)
+	for _, n := range nodes {
+		n.serve()
+	}
+	leader := waitForLeader(t, nodes)
+
+	want := commands(10)
+	apply(t, leader, want...)
+
+	for _, n := range nodes {
+		waitFor(t, "replication to "+n.cfg.ID, func() bool {
+			return slices.Equal(n.fsm.list(), want)
+		})
+	}
+
+	for _, n := range nodes {
+		if n == leader {
+			continue
+		}
+		if _, err := n.Apply([]byte("rejected")); !errors.Is(err, ErrNotLeader) {
+			t.Errorf("Apply on follower %s returned %v, want %v", n.cfg.ID, err, ErrNotLeader)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	nodes := newCluster(t, 3, 5)
+	lagging := nodes[2]
+	// The first snapshot sent to the lagging node fails to restore, so the leader must send it again.
+	lagging.fsm.failRestores = 1
+	nodes[0].serve()
+	nodes[1].serve()
+
+	leader := waitForLeader(t, nodes[:2])
+	want := commands(20)
+	apply(t, leader, want...)
+	waitFor(t, "the leader to compact its log", func() bool {
+		leader.mu.Lock()
+		defer leader.mu.Unlock()
+		return leader.snapshotIndex > 0
+	})
+
+	lagging.serve()
+	waitFor(t, "the lagging node to catch up", func() bool {
+		return slices.Equal(lagging.fsm.list(), want)
+	})
+	lagging.fsm.mu.Lock()
+	defer lagging.fsm.mu.Unlock()
+	if lagging.fsm.restores == 0 {
+		t.Error("lagging node caught up without installing a snapshot")
+	}
+}
+
+func TestHandlerRequiresSecret(t *testing.T) {
+	nodes := newCluster(t, 1, 0)
+	nodes[0].serve()
+
+	url := fmt.Sprintf("http://%s/raft/vote", nodes[0].cfg.ID)
+	res, err := http.Post(url, "application/json", strings.NewReader(`{"Term":100,"CandidateID":"intruder"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated vote returned %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	if term := nodes[0].Status().Term; term >= 100 {
+		t.Errorf("unauthenticated vote moved the node to term %d", term)
+	}
+}
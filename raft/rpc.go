@@ -0,0 +1,130 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Entry is a single command in the replicated log. Entries with a nil Command are no-ops a new leader appends
+// to commit the entries of earlier terms.
+type Entry struct {
+	Index   uint64
+	Term    uint64
+	Command []byte
+}
+
+// VoteRequest is sent by candidates to gather votes.
+type VoteRequest struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// VoteResponse is a peer's answer to a VoteRequest.
+type VoteResponse struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendRequest is sent by the leader to replicate log entries; with no entries it serves as a heartbeat.
+type AppendRequest struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendResponse is a follower's answer to an AppendRequest. When Success is false ConflictIndex is the index
+// the leader should retry from.
+type AppendResponse struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// SnapshotRequest is sent by the leader to a follower whose next entry has already been compacted away.
+type SnapshotRequest struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Data              []byte
+}
+
+// SnapshotResponse is a follower's answer to a SnapshotRequest. Success is false when the follower could not
+// install the snapshot, and the leader should send it again.
+type SnapshotResponse struct {
+	Term    uint64
+	Success bool
+}
+
+// Handler returns the HTTP handler serving the Node's RPCs; it must be mounted at /raft/ on the address used
+// as the Node's ID. Requests must carry the cluster's Secret as a bearer token.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /raft/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req VoteRequest
+		serveRPC(w, r, &req, func() any { return n.handleVote(req) })
+	})
+	mux.HandleFunc("POST /raft/append", func(w http.ResponseWriter, r *http.Request) {
+		var req AppendRequest
+		serveRPC(w, r, &req, func() any { return n.handleAppend(req) })
+	})
+	mux.HandleFunc("POST /raft/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var req SnapshotRequest
+		serveRPC(w, r, &req, func() any { return n.handleSnapshot(req) })
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(n.cfg.Secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func serveRPC(w http.ResponseWriter, r *http.Request, req any, handle func() any) {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(handle()); err != nil {
+		log.Printf("failed to encode raft response: %v\n", err)
+	}
+}
+
+// call sends an RPC to the peer at addr and decodes its response into resp.
+func (n *Node) call(addr, rpc string, req, resp any) error {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/raft/%s", addr, rpc), bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+n.cfg.Secret)
+
+	r, err := n.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("raft %s to %s returned %d", rpc, addr, r.StatusCode)
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
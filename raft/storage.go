@@ -0,0 +1,176 @@
+package raft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket     = []byte("meta")
+	logBucket      = []byte("log")
+	snapshotBucket = []byte("snapshot")
+
+	termKey          = []byte("term")
+	voteKey          = []byte("vote")
+	snapshotIndexKey = []byte("snapshot_index")
+	snapshotTermKey  = []byte("snapshot_term")
+	snapshotDataKey  = []byte("data")
+)
+
+// storage durably holds a Node's term, vote, log entries and latest snapshot in a BoltDB file.
+type storage struct {
+	db *bolt.DB
+}
+
+// persistentState is everything a Node reloads from storage when it restarts.
+type persistentState struct {
+	term          uint64
+	votedFor      string
+	snapshotIndex uint64
+	snapshotTerm  uint64
+	snapshot      []byte
+	entries       []Entry
+}
+
+// openStorage opens (creating if necessary) the Node's BoltDB file within dir.
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create raft directory %s: %w", dir, err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "raft.db"), 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft storage: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{metaBucket, logBucket, snapshotBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create raft buckets: %w", err)
+	}
+	return &storage{db: db}, nil
+}
+
+// load reads the Node's persistent state.
+func (s *storage) load() (persistentState, error) {
+	var ps persistentState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		ps.term = decodeUint(meta.Get(termKey))
+		ps.votedFor = string(meta.Get(voteKey))
+		ps.snapshotIndex = decodeUint(meta.Get(snapshotIndexKey))
+		ps.snapshotTerm = decodeUint(meta.Get(snapshotTermKey))
+		if data := tx.Bucket(snapshotBucket).Get(snapshotDataKey); data != nil {
+			ps.snapshot = append([]byte(nil), data...)
+		}
+
+		return tx.Bucket(logBucket).ForEach(func(k, v []byte) error {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("failed to unmarshal log entry %d: %w", decodeUint(k), err)
+			}
+			ps.entries = append(ps.entries, e)
+			return nil
+		})
+	})
+	return ps, err
+}
+
+// setTermAndVote durably records the current term and the candidate voted for within it.
+func (s *storage) setTermAndVote(term uint64, votedFor string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if err := meta.Put(termKey, encodeUint(term)); err != nil {
+			return err
+		}
+		return meta.Put(voteKey, []byte(votedFor))
+	})
+}
+
+// append durably adds the entries to the log.
+func (s *storage) append(entries []Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logBucket)
+		for _, e := range entries {
+			buf, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(encodeUint(e.Index), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// truncateFrom removes every log entry at or after index.
+func (s *storage) truncateFrom(index uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(logBucket).Cursor()
+		for k, _ := c.Seek(encodeUint(index)); k != nil; k, _ = c.Seek(encodeUint(index)) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// saveSnapshot durably replaces the snapshot and removes every log entry it covers. When discardLog is set
+// the whole log is removed, as it no longer agrees with the snapshot.
+func (s *storage) saveSnapshot(index, term uint64, data []byte, discardLog bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if err := meta.Put(snapshotIndexKey, encodeUint(index)); err != nil {
+			return err
+		}
+		if err := meta.Put(snapshotTermKey, encodeUint(term)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(snapshotBucket).Put(snapshotDataKey, data); err != nil {
+			return err
+		}
+
+		c := tx.Bucket(logBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			if !discardLog && decodeUint(k) > index {
+				break
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// close releases the BoltDB file.
+func (s *storage) close() error {
+	return s.db.Close()
+}
+
+func encodeUint(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+func decodeUint(buf []byte) uint64 {
+	if len(buf) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
@@ -18,7 +18,7 @@ type Epvm struct {
 func (g *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
 	var candidates []*node.Node
 	for node := range nodes {
-		if checkDisk(t, nodes[node].Disk-nodes[node].DiskAllocated) {
+		if checkDisk(t, nodes[node].DiskAvailable()) {
 			candidates = append(candidates, nodes[node])
 		}
 	}
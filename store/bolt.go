@@ -0,0 +1,76 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// revisionBucket names the bucket holding the revisions of the keys in the given bucket.
+func revisionBucket(bucket string) []byte {
+	return []byte(bucket + "_revisions")
+}
+
+// createBuckets creates the value and revision buckets for a BoltDB backed store.
+func createBuckets(db *bolt.DB, bucket string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+		}
+		if _, err := tx.CreateBucketIfNotExists(revisionBucket(bucket)); err != nil {
+			return fmt.Errorf("failed to create revision bucket for %s: %w", bucket, err)
+		}
+		return nil
+	})
+}
+
+// readRevision returns the revision key was last written at within the transaction, or 0 if it has never been written.
+func readRevision(tx *bolt.Tx, bucket, key string) uint64 {
+	v := tx.Bucket(revisionBucket(bucket)).Get([]byte(key))
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// writeRevisioned stores buf at key and stamps it with the bucket's next revision.
+func writeRevisioned(tx *bolt.Tx, bucket, key string, buf []byte) (uint64, error) {
+	revs := tx.Bucket(revisionBucket(bucket))
+	rev, err := revs.NextSequence()
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate revision for %s: %w", key, err)
+	}
+
+	if err := tx.Bucket([]byte(bucket)).Put([]byte(key), buf); err != nil {
+		return 0, err
+	}
+
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, rev)
+	if err := revs.Put([]byte(key), encoded); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// deleteRevisioned removes key, and its revision, from the bucket. It returns the revision of the
+// deletion along with a copy of the value that was removed.
+func deleteRevisioned(tx *bolt.Tx, bucket, key string) (uint64, []byte, error) {
+	b := tx.Bucket([]byte(bucket))
+	v := b.Get([]byte(key))
+	if v == nil {
+		return 0, nil, fmt.Errorf("failed to find %s in %s; does it exist?\n", key, bucket)
+	}
+	old := append([]byte(nil), v...)
+
+	revs := tx.Bucket(revisionBucket(bucket))
+	rev, err := revs.NextSequence()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to allocate revision for %s: %w", key, err)
+	}
+	if err := b.Delete([]byte(key)); err != nil {
+		return 0, nil, err
+	}
+	return rev, old, revs.Delete([]byte(key))
+}
@@ -0,0 +1,300 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+	bolt "go.etcd.io/bbolt"
+)
+
+// EventStore is a persistent store of TaskEvents held in a BoltDB file on disk.
+type EventStore struct {
+	DB       *bolt.DB
+	DbFile   string
+	FileMode os.FileMode
+	Bucket   string
+	watchers Watchers[*task.TaskEvent]
+}
+
+// NewEventStore opens (creating if necessary) the BoltDB file at the given path,
+// ensures the named bucket exists and returns a reference to the EventStore.
+func NewEventStore(file string, mode os.FileMode, bucket string) (*EventStore, error) {
+	db, err := bolt.Open(file, mode, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+
+	e := EventStore{
+		DB:       db,
+		DbFile:   file,
+		FileMode: mode,
+		Bucket:   bucket,
+	}
+
+	if err := e.CreateBucket(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CreateBucket creates the EventStore's bucket, the bucket tracking its revisions and the
+// bucket indexing taskEvents by Task ID, if they do not already exist.
+func (e *EventStore) CreateBucket() error {
+	if err := createBuckets(e.DB, e.Bucket); err != nil {
+		return err
+	}
+	return e.DB.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(e.indexBucket()); err != nil {
+			return fmt.Errorf("failed to create task index for %s: %w", e.Bucket, err)
+		}
+		return nil
+	})
+}
+
+// indexBucket names the bucket holding, for each Task ID, a nested bucket of the keys of its taskEvents.
+func (e *EventStore) indexBucket() []byte {
+	return []byte(e.Bucket + "_by_task")
+}
+
+// indexKey orders a taskEvent within its Task's index by Timestamp, then by key.
+func indexKey(key string, event *task.TaskEvent) []byte {
+	k := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(event.Timestamp.UnixNano()))
+	return append(k, key...)
+}
+
+// writeIndexed stores the taskEvent and replaces any index entry left by a previous version of it.
+func (e *EventStore) writeIndexed(tx *bolt.Tx, key string, event *task.TaskEvent, buf []byte) (uint64, error) {
+	if err := e.unindex(tx, key); err != nil {
+		return 0, err
+	}
+
+	rev, err := writeRevisioned(tx, e.Bucket, key, buf)
+	if err != nil {
+		return 0, err
+	}
+
+	tasks, err := tx.Bucket(e.indexBucket()).CreateBucketIfNotExists([]byte(event.Task.ID.String()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to index taskEvent %s: %w", key, err)
+	}
+	return rev, tasks.Put(indexKey(key, event), []byte(key))
+}
+
+// unindex removes the index entry of the taskEvent currently held at key, if there is one.
+func (e *EventStore) unindex(tx *bolt.Tx, key string) error {
+	v := tx.Bucket([]byte(e.Bucket)).Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+
+	var old task.TaskEvent
+	if err := json.Unmarshal(v, &old); err != nil {
+		return fmt.Errorf("failed to unmarshal taskEvent %s: %w", key, err)
+	}
+	tasks := tx.Bucket(e.indexBucket()).Bucket([]byte(old.Task.ID.String()))
+	if tasks == nil {
+		return nil
+	}
+	return tasks.Delete(indexKey(key, &old))
+}
+
+// Close releases the underlying BoltDB file.
+func (e *EventStore) Close() error {
+	return e.DB.Close()
+}
+
+// Get retrieves a taskEvent from the EventStore and returns it.
+func (e *EventStore) Get(key string) (*task.TaskEvent, error) {
+	event, _, err := e.GetWithRevision(key)
+	return event, err
+}
+
+// GetWithRevision retrieves a taskEvent from the EventStore along with its current revision.
+func (e *EventStore) GetWithRevision(key string) (*task.TaskEvent, uint64, error) {
+	var event task.TaskEvent
+	var rev uint64
+	err := e.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(e.Bucket)).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("failed to find taskEvent %s; does it exist?\n", key)
+		}
+		rev = readRevision(tx, e.Bucket, key)
+		return json.Unmarshal(v, &event)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &event, rev, nil
+}
+
+// Put inserts a key value pair into the EventStore.
+func (e *EventStore) Put(key string, value *task.TaskEvent) error {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal taskEvent %s: %w", key, err)
+	}
+
+	var rev uint64
+	err = e.DB.Update(func(tx *bolt.Tx) error {
+		rev, err = e.writeIndexed(tx, key, value, buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	e.notifyPut(key, rev, value)
+	return nil
+}
+
+// CompareAndSwap inserts the taskEvent at key only if the key's revision still matches the given revision.
+func (e *EventStore) CompareAndSwap(key string, revision uint64, value *task.TaskEvent) (uint64, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal taskEvent %s: %w", key, err)
+	}
+
+	var rev uint64
+	err = e.DB.Update(func(tx *bolt.Tx) error {
+		if current := readRevision(tx, e.Bucket, key); current != revision {
+			return fmt.Errorf("taskEvent %s at revision %d, expected %d: %w", key, current, revision, ErrRevisionMismatch)
+		}
+		rev, err = e.writeIndexed(tx, key, value, buf)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	e.notifyPut(key, rev, value)
+	return rev, nil
+}
+
+// notifyPut tells the EventStore's watchers that a copy of the taskEvent was written at key.
+func (e *EventStore) notifyPut(key string, rev uint64, value *task.TaskEvent) {
+	cpy := *value
+	e.watchers.Notify(WatchEvent[*task.TaskEvent]{Op: OpPut, Key: key, Revision: rev, Value: &cpy})
+}
+
+// Delete removes the taskEvent held at key from the EventStore.
+func (e *EventStore) Delete(key string) error {
+	var rev uint64
+	var old []byte
+	err := e.DB.Update(func(tx *bolt.Tx) error {
+		if err := e.unindex(tx, key); err != nil {
+			return err
+		}
+		var err error
+		rev, old, err = deleteRevisioned(tx, e.Bucket, key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var event task.TaskEvent
+	if err := json.Unmarshal(old, &event); err != nil {
+		log.Printf("failed to unmarshal deleted taskEvent %s: %s\n", key, err)
+	}
+	e.watchers.Notify(WatchEvent[*task.TaskEvent]{Op: OpDelete, Key: key, Revision: rev, Value: &event})
+	return nil
+}
+
+// List decodes every taskEvent held in the EventStore's bucket and returns them as a slice.
+func (e *EventStore) List() ([]*task.TaskEvent, error) {
+	var eventList []*task.TaskEvent
+	err := e.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(e.Bucket))
+		return b.ForEach(func(k, v []byte) error {
+			var event task.TaskEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return fmt.Errorf("failed to unmarshal taskEvent %s: %w", k, err)
+			}
+			eventList = append(eventList, &event)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return eventList, nil
+}
+
+// Query returns a page of the taskEvents in the EventStore accepted by match, ordered by key.
+func (e *EventStore) Query(match func(*task.TaskEvent) bool, cursor string, limit int) ([]*task.TaskEvent, string, error) {
+	var page []*task.TaskEvent
+	var next string
+	err := e.DB.View(func(tx *bolt.Tx) error {
+		var last []byte
+		c := tx.Bucket([]byte(e.Bucket)).Cursor()
+		k, v := c.Seek([]byte(cursor))
+		if k != nil && string(k) == cursor {
+			k, v = c.Next()
+		}
+		for ; k != nil; k, v = c.Next() {
+			var event task.TaskEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return fmt.Errorf("failed to unmarshal taskEvent %s: %w", k, err)
+			}
+			if !match(&event) {
+				continue
+			}
+			if limit > 0 && len(page) == limit {
+				next = string(last)
+				return nil
+			}
+			page = append(page, &event)
+			last = k
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// ListByTask returns the taskEvents recorded for the given Task ID, ordered by Timestamp.
+func (e *EventStore) ListByTask(taskID string) ([]*task.TaskEvent, error) {
+	eventList := []*task.TaskEvent{}
+	err := e.DB.View(func(tx *bolt.Tx) error {
+		tasks := tx.Bucket(e.indexBucket()).Bucket([]byte(taskID))
+		if tasks == nil {
+			return nil
+		}
+
+		events := tx.Bucket([]byte(e.Bucket))
+		return tasks.ForEach(func(_, key []byte) error {
+			var event task.TaskEvent
+			if err := json.Unmarshal(events.Get(key), &event); err != nil {
+				return fmt.Errorf("failed to unmarshal taskEvent %s: %w", key, err)
+			}
+			eventList = append(eventList, &event)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return eventList, nil
+}
+
+// Watch streams the writes made to the EventStore until ctx is done.
+func (e *EventStore) Watch(ctx context.Context) <-chan WatchEvent[*task.TaskEvent] {
+	return e.watchers.Watch(ctx)
+}
+
+// Count returns the number of taskEvents in the EventStore's bucket.
+func (e *EventStore) Count() (int, error) {
+	count := 0
+	err := e.DB.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket([]byte(e.Bucket)).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
@@ -1,54 +1,170 @@
 package store
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/marktlinn/Gorcherstrator/task"
 )
 
 // InMemoryEventStore is a map structure store of TaskEvents held in memory.
+// TaskEvents are deep copied on the way in and out so callers never share a TaskEvent with the store.
 type InMemoryEventStore struct {
-	DB map[string]*task.TaskEvent
+	DB        map[string]*task.TaskEvent
+	Revisions map[string]uint64
+	// ByTask indexes the keys of the TaskEvents recorded for each Task ID.
+	ByTask   map[string]map[string]struct{}
+	mu       sync.RWMutex
+	revision uint64
+	watchers Watchers[*task.TaskEvent]
 }
 
-// InMemoryEventStore creates a new NewInMemoryEventStore and returns a reference to it.
+// NewInMemoryEventStore creates a new InMemoryEventStore and returns a reference to it.
 func NewInMemoryEventStore() *InMemoryEventStore {
 	return &InMemoryEventStore{
-		DB: make(map[string]*task.TaskEvent),
+		DB:        make(map[string]*task.TaskEvent),
+		Revisions: make(map[string]uint64),
+		ByTask:    make(map[string]map[string]struct{}),
 	}
 }
 
 // Get retrieves a taskEvent from the InMemoryEventStore and returns it.
-func (i *InMemoryEventStore) Get(key string) (any, error) {
+func (i *InMemoryEventStore) Get(key string) (*task.TaskEvent, error) {
+	event, _, err := i.GetWithRevision(key)
+	return event, err
+}
+
+// GetWithRevision retrieves a taskEvent from the InMemoryEventStore along with its current revision.
+func (i *InMemoryEventStore) GetWithRevision(key string) (*task.TaskEvent, uint64, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	event, ok := i.DB[key]
 	if !ok {
-		return nil, fmt.Errorf("failed to find task %s; does it exists?\n", key)
+		return nil, 0, fmt.Errorf("failed to find taskEvent %s; does it exist?\n", key)
+	}
+	return event.Clone(), i.Revisions[key], nil
+}
+
+// Put inserts a key value pair into the InMemoryEventStore.
+func (i *InMemoryEventStore) Put(key string, value *task.TaskEvent) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.put(key, value)
+	return nil
+}
+
+// CompareAndSwap inserts the taskEvent at key only if the key's revision still matches the given revision.
+func (i *InMemoryEventStore) CompareAndSwap(key string, revision uint64, value *task.TaskEvent) (uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.Revisions[key] != revision {
+		return 0, fmt.Errorf("taskEvent %s at revision %d, expected %d: %w", key, i.Revisions[key], revision, ErrRevisionMismatch)
 	}
-	return event, nil
+	return i.put(key, value), nil
 }
 
-// Put inserts a key value pair into the NewInMemoryEventStore, asserting first that the value is a pointer to a task.TaskEvent.
-func (i *InMemoryEventStore) Put(key string, value any) error {
-	event, ok := value.(*task.TaskEvent)
+// put stores a copy of the taskEvent and bumps its revision. The caller must hold the write lock.
+func (i *InMemoryEventStore) put(key string, event *task.TaskEvent) uint64 {
+	if old, ok := i.DB[key]; ok {
+		i.unindex(key, old)
+	}
+
+	cpy := event.Clone()
+	i.revision++
+	i.DB[key] = cpy
+	i.Revisions[key] = i.revision
+
+	notified := cpy.Clone()
+	i.watchers.Notify(WatchEvent[*task.TaskEvent]{Op: OpPut, Key: key, Revision: i.revision, Value: notified})
+
+	taskID := cpy.Task.ID.String()
+	if i.ByTask[taskID] == nil {
+		i.ByTask[taskID] = make(map[string]struct{})
+	}
+	i.ByTask[taskID][key] = struct{}{}
+	return i.revision
+}
+
+// unindex removes the taskEvent held at key from the ByTask index. The caller must hold the write lock.
+func (i *InMemoryEventStore) unindex(key string, event *task.TaskEvent) {
+	taskID := event.Task.ID.String()
+	delete(i.ByTask[taskID], key)
+	if len(i.ByTask[taskID]) == 0 {
+		delete(i.ByTask, taskID)
+	}
+}
+
+// Delete removes the taskEvent held at key from the InMemoryEventStore.
+func (i *InMemoryEventStore) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	event, ok := i.DB[key]
 	if !ok {
-		return fmt.Errorf("failed to assert value %s as type *task.Task\n", value)
+		return fmt.Errorf("failed to find taskEvent %s; does it exist?\n", key)
 	}
-	i.DB[key] = event
+	i.unindex(key, event)
+	delete(i.DB, key)
+	delete(i.Revisions, key)
+
+	i.revision++
+	i.watchers.Notify(WatchEvent[*task.TaskEvent]{Op: OpDelete, Key: key, Revision: i.revision, Value: event})
 	return nil
 }
 
-// List creates a slice equal to the number of task.TaskEvents in the InMemoryEventStore
+// Watch streams the writes made to the InMemoryEventStore until ctx is done.
+func (i *InMemoryEventStore) Watch(ctx context.Context) <-chan WatchEvent[*task.TaskEvent] {
+	return i.watchers.Watch(ctx)
+}
+
+// List creates a slice with capacity for the number of taskEvents in the InMemoryEventStore
 // DB, appends each taskEvent to the list and returns the list.
-func (i *InMemoryEventStore) List() (any, error) {
-	var eventList []*task.TaskEvent = make([]*task.TaskEvent, len(i.DB))
+func (i *InMemoryEventStore) List() ([]*task.TaskEvent, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var eventList []*task.TaskEvent = make([]*task.TaskEvent, 0, len(i.DB))
+
+	for _, e := range i.DB {
+		eventList = append(eventList, e.Clone())
+	}
+	return eventList, nil
+}
+
+// Query returns a page of the taskEvents in the InMemoryEventStore accepted by match, ordered by key.
+func (i *InMemoryEventStore) Query(match func(*task.TaskEvent) bool, cursor string, limit int) ([]*task.TaskEvent, string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	keys := make([]string, 0, len(i.DB))
+	for k := range i.DB {
+		keys = append(keys, k)
+	}
+
+	page, next := paginate(keys, func(key string) *task.TaskEvent {
+		return i.DB[key].Clone()
+	}, match, cursor, limit)
+	return page, next, nil
+}
+
+// ListByTask returns copies of the taskEvents recorded for the given Task ID, ordered by Timestamp.
+func (i *InMemoryEventStore) ListByTask(taskID string) ([]*task.TaskEvent, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 
-	for _, t := range i.DB {
-		eventList = append(eventList, t)
+	eventList := make([]*task.TaskEvent, 0, len(i.ByTask[taskID]))
+	for key := range i.ByTask[taskID] {
+		eventList = append(eventList, i.DB[key].Clone())
 	}
+	sortEvents(eventList)
 	return eventList, nil
 }
 
 // Count returns the number of taskEvents in the InMemoryEventStore DB.
 func (i *InMemoryEventStore) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.DB), nil
 }
@@ -1,54 +1,134 @@
 package store
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/marktlinn/Gorcherstrator/task"
 )
 
 // InMemoryTaskStore is a map structure store of Tasks held in memory.
+// Tasks are deep copied on the way in and out so callers never share a Task with the store.
 type InMemoryTaskStore struct {
-	DB map[string]*task.Task
+	DB        map[string]*task.Task
+	Revisions map[string]uint64
+	mu        sync.RWMutex
+	revision  uint64
+	watchers  Watchers[*task.Task]
 }
 
 // NewInMemoryTaskStore creates a new InMemoryTaskStore and returns a reference to it.
 func NewInMemoryTaskStore() *InMemoryTaskStore {
 	return &InMemoryTaskStore{
-		DB: make(map[string]*task.Task),
+		DB:        make(map[string]*task.Task),
+		Revisions: make(map[string]uint64),
 	}
 }
 
 // Get retrieves a task from the InMemoryTaskStore and returns it.
-func (i *InMemoryTaskStore) Get(key string) (any, error) {
-	task, ok := i.DB[key]
+func (i *InMemoryTaskStore) Get(key string) (*task.Task, error) {
+	t, _, err := i.GetWithRevision(key)
+	return t, err
+}
+
+// GetWithRevision retrieves a task from the InMemoryTaskStore along with its current revision.
+func (i *InMemoryTaskStore) GetWithRevision(key string) (*task.Task, uint64, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	t, ok := i.DB[key]
 	if !ok {
-		return nil, fmt.Errorf("failed to find task %s; does it exist?\n", key)
+		return nil, 0, fmt.Errorf("failed to find task %s; does it exist?\n", key)
 	}
-	return task, nil
+	return t.Clone(), i.Revisions[key], nil
+}
+
+// Put inserts a key value pair into the InMemoryTaskStore.
+func (i *InMemoryTaskStore) Put(key string, value *task.Task) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.put(key, value)
+	return nil
+}
+
+// CompareAndSwap inserts the task at key only if the key's revision still matches the given revision.
+func (i *InMemoryTaskStore) CompareAndSwap(key string, revision uint64, value *task.Task) (uint64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.Revisions[key] != revision {
+		return 0, fmt.Errorf("task %s at revision %d, expected %d: %w", key, i.Revisions[key], revision, ErrRevisionMismatch)
+	}
+	return i.put(key, value), nil
+}
+
+// put stores a copy of the task and bumps its revision. The caller must hold the write lock.
+func (i *InMemoryTaskStore) put(key string, t *task.Task) uint64 {
+	cpy := t.Clone()
+	i.revision++
+	i.DB[key] = cpy
+	i.Revisions[key] = i.revision
+
+	notified := cpy.Clone()
+	i.watchers.Notify(WatchEvent[*task.Task]{Op: OpPut, Key: key, Revision: i.revision, Value: notified})
+	return i.revision
 }
 
-// Put inserts a key value pair into the NewInMemoryTaskStore, asserting first that the value is a pointer to a task.Task.
-func (i *InMemoryTaskStore) Put(key string, value any) error {
-	task, ok := value.(*task.Task)
+// Delete removes the task held at key from the InMemoryTaskStore.
+func (i *InMemoryTaskStore) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	t, ok := i.DB[key]
 	if !ok {
-		return fmt.Errorf("failed to assert value %s as type *task.Task\n", value)
+		return fmt.Errorf("failed to find task %s; does it exist?\n", key)
 	}
-	i.DB[key] = task
+	delete(i.DB, key)
+	delete(i.Revisions, key)
+
+	i.revision++
+	i.watchers.Notify(WatchEvent[*task.Task]{Op: OpDelete, Key: key, Revision: i.revision, Value: t})
 	return nil
 }
 
-// List creates a slice equal to the number of tasks in the InMemoryTaskStore
+// Watch streams the writes made to the InMemoryTaskStore until ctx is done.
+func (i *InMemoryTaskStore) Watch(ctx context.Context) <-chan WatchEvent[*task.Task] {
+	return i.watchers.Watch(ctx)
+}
+
+// List creates a slice with capacity for the number of tasks in the InMemoryTaskStore
 // DB, appends each task to the list and returns the list.
-func (i *InMemoryTaskStore) List() (any, error) {
-	var taskList []*task.Task = make([]*task.Task, len(i.DB))
+func (i *InMemoryTaskStore) List() ([]*task.Task, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var taskList []*task.Task = make([]*task.Task, 0, len(i.DB))
 
 	for _, t := range i.DB {
-		taskList = append(taskList, t)
+		taskList = append(taskList, t.Clone())
 	}
 	return taskList, nil
 }
 
+// Query returns a page of the tasks in the InMemoryTaskStore accepted by match, ordered by key.
+func (i *InMemoryTaskStore) Query(match func(*task.Task) bool, cursor string, limit int) ([]*task.Task, string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	keys := make([]string, 0, len(i.DB))
+	for k := range i.DB {
+		keys = append(keys, k)
+	}
+
+	page, next := paginate(keys, func(key string) *task.Task {
+		return i.DB[key].Clone()
+	}, match, cursor, limit)
+	return page, next, nil
+}
+
 // Count returns the number of tasks in the InMemoryTaskStore DB.
 func (i *InMemoryTaskStore) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.DB), nil
 }
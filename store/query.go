@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// NextCursorHeader is the response header the Apis use to return the cursor for the next page of a query.
+const NextCursorHeader = "X-Next-Cursor"
+
+// TaskQuery describes a filter over Tasks along with the page of results to return.
+// Zero valued fields do not constrain the results.
+type TaskQuery struct {
+	States []task.State
+	Worker string
+	Name   string
+	Kind   string
+	// Ready, when set, only matches Running Tasks which are, or are not, Ready.
+	Ready         *bool
+	Labels        map[string]string
+	StartedAfter  time.Time
+	StartedBefore time.Time
+	// Cursor is the key of the last Task of the previous page.
+	Cursor string
+	// Limit is the maximum number of Tasks returned, 0 returns every match.
+	Limit int
+}
+
+// Matches reports whether the Task satisfies every filter of the TaskQuery.
+func (q TaskQuery) Matches(t *task.Task) bool {
+	if len(q.States) > 0 && !task.Includes(q.States, t.State) {
+		return false
+	}
+	if q.Worker != "" && t.Worker != q.Worker {
+		return false
+	}
+	if q.Name != "" && t.Name != q.Name {
+		return false
+	}
+	if q.Kind != "" && kind(t) != q.Kind {
+		return false
+	}
+	if q.Ready != nil && (t.State != task.Running || t.Ready != *q.Ready) {
+		return false
+	}
+	for k, v := range q.Labels {
+		if t.Labels[k] != v {
+			return false
+		}
+	}
+	if !q.StartedAfter.IsZero() && t.StartTime.Before(q.StartedAfter) {
+		return false
+	}
+	if !q.StartedBefore.IsZero() && !t.StartTime.Before(q.StartedBefore) {
+		return false
+	}
+	return true
+}
+
+// kind returns the Task's Kind, treating a Task without one as a service.
+func kind(t *task.Task) string {
+	if t.Kind == "" {
+		return task.KindService
+	}
+	return t.Kind
+}
+
+// QueryTasks runs the TaskQuery against the given Store, returning the matching page of Tasks
+// and the cursor for the next page, which is empty once there are no more results.
+func QueryTasks(s Store[*task.Task], q TaskQuery) ([]*task.Task, string, error) {
+	return s.Query(q.Matches, q.Cursor, q.Limit)
+}
+
+// ParseTaskQuery builds a TaskQuery from the query parameters of a `GET /tasks` request.
+// Supported parameters are:
+// state - a task state name, may be repeated or comma separated
+// worker - the name of the worker running the task
+// name - the name of the task
+// kind - the kind of the task, service or batch
+// ready - true or false, whether the running task is ready
+// label - a key=value pair, may be repeated
+// started_after, started_before - RFC3339 timestamps bounding the task's StartTime
+// cursor, limit - the page of results to return.
+func ParseTaskQuery(values url.Values) (TaskQuery, error) {
+	q := TaskQuery{
+		Worker: values.Get("worker"),
+		Name:   values.Get("name"),
+		Kind:   values.Get("kind"),
+		Cursor: values.Get("cursor"),
+	}
+
+	for _, v := range values["state"] {
+		for _, name := range strings.Split(v, ",") {
+			s, err := task.ParseState(strings.TrimSpace(name))
+			if err != nil {
+				return q, err
+			}
+			q.States = append(q.States, s)
+		}
+	}
+
+	for _, v := range values["label"] {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return q, fmt.Errorf("label %q must be of the form key=value", v)
+		}
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		q.Labels[key] = value
+	}
+
+	var err error
+	if v := values.Get("started_after"); v != "" {
+		if q.StartedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid started_after %q: %w", v, err)
+		}
+	}
+	if v := values.Get("started_before"); v != "" {
+		if q.StartedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid started_before %q: %w", v, err)
+		}
+	}
+	if v := values.Get("ready"); v != "" {
+		ready, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid ready %q", v)
+		}
+		q.Ready = &ready
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+// paginate walks the keys in ascending order, starting after the cursor, and collects up to limit
+// values accepted by match. The returned cursor is empty when no further values match.
+func paginate[T any](keys []string, lookup func(key string) T, match func(T) bool, cursor string, limit int) ([]T, string) {
+	sort.Strings(keys)
+	start := sort.SearchStrings(keys, cursor)
+	if start < len(keys) && keys[start] == cursor {
+		start++
+	}
+
+	var page []T
+	var last string
+	for _, k := range keys[start:] {
+		v := lookup(k)
+		if !match(v) {
+			continue
+		}
+		if limit > 0 && len(page) == limit {
+			return page, last
+		}
+		page = append(page, v)
+		last = k
+	}
+	return page, ""
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// RetentionPolicy bounds how long, and how many, finished records are kept.
+// A zero MaxAge or MaxCount does not limit retention.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+// ParseRetentionPolicy parses a RetentionPolicy written as "maxAge,maxCount", such as "24h,1000", where
+// maxAge is a duration and either may be 0 to leave retention unlimited by it.
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	age, count, ok := strings.Cut(s, ",")
+	if !ok {
+		return RetentionPolicy{}, fmt.Errorf("retention policy %q must be of the form maxAge,maxCount", s)
+	}
+	var p RetentionPolicy
+	var err error
+	if age = strings.TrimSpace(age); age != "0" {
+		if p.MaxAge, err = time.ParseDuration(age); err != nil || p.MaxAge < 0 {
+			return RetentionPolicy{}, fmt.Errorf("invalid retention max age %q", age)
+		}
+	}
+	if p.MaxCount, err = strconv.Atoi(strings.TrimSpace(count)); err != nil || p.MaxCount < 0 {
+		return RetentionPolicy{}, fmt.Errorf("invalid retention max count %q", count)
+	}
+	return p, nil
+}
+
+// PruneReport lists the keys removed from the stores by a garbage collection run.
+type PruneReport struct {
+	Tasks  []string
+	Events []string
+}
+
+// String summarises the PruneReport for logging.
+func (r PruneReport) String() string {
+	return fmt.Sprintf("removed %d tasks %v and %d events", len(r.Tasks), r.Tasks, len(r.Events))
+}
+
+// PruneTasks deletes the Tasks in a terminal State that fall outside the RetentionPolicy, returning the keys removed.
+// A Task's age is measured from its FinishTime or, without one, the time it last changed State or started.
+func PruneTasks(s Store[*task.Task], p RetentionPolicy, now time.Time) ([]string, error) {
+	tasks, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var finished []*task.Task
+	for _, t := range tasks {
+		if task.IsTerminal(t.State) {
+			finished = append(finished, t)
+		}
+	}
+
+	return prune(s, finished, p, now,
+		func(t *task.Task) string { return t.ID.String() },
+		func(t *task.Task) time.Time {
+			switch {
+			case !t.FinishTime.IsZero():
+				return t.FinishTime
+			case !t.StateTime.IsZero():
+				return t.StateTime
+			}
+			return t.StartTime
+		},
+	)
+}
+
+// PruneEvents deletes the TaskEvents that fall outside the RetentionPolicy, returning the keys removed.
+func PruneEvents(s Store[*task.TaskEvent], p RetentionPolicy, now time.Time) ([]string, error) {
+	events, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return prune(s, events, p, now,
+		func(e *task.TaskEvent) string { return e.ID.String() },
+		func(e *task.TaskEvent) time.Time { return e.Timestamp },
+	)
+}
+
+// prune deletes the candidates older than the policy's MaxAge, and all but the newest MaxCount of them.
+// Candidates without a time have no known age, so only MaxCount removes them.
+func prune[T any](s Store[T], candidates []T, p RetentionPolicy, now time.Time, key func(T) string, at func(T) time.Time) ([]string, error) {
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return at(candidates[a]).After(at(candidates[b]))
+	})
+
+	var removed []string
+	for i, c := range candidates {
+		expired := p.MaxAge > 0 && !at(c).IsZero() && now.Sub(at(c)) > p.MaxAge
+		overflow := p.MaxCount > 0 && i >= p.MaxCount
+		if !expired && !overflow {
+			continue
+		}
+		if err := s.Delete(key(c)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, key(c))
+	}
+	return removed, nil
+}
@@ -1,13 +1,88 @@
 package store
 
-// Store interfce defines the generic interface for possible datastores to implement.
-type Store interface {
-	Put(key string, value any) error
-	Get(key string) (any, error)
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// Store interfce defines the generic interface for possible datastores of values of type T to implement.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Store[T any] interface {
+	Put(key string, value T) error
+	Get(key string) (T, error)
+	Delete(key string) error
 	Count() (int, error)
-	List() (any, error)
+	List() ([]T, error)
+	// Query returns, in key order, up to limit values after the cursor key that are accepted by match,
+	// along with the cursor for the next page. A limit of 0 returns every match.
+	Query(match func(T) bool, cursor string, limit int) ([]T, string, error)
+	// GetWithRevision retrieves the value held at key along with the revision it was last written at.
+	GetWithRevision(key string) (T, uint64, error)
+	// CompareAndSwap writes value to key only if the key is still at the given revision, returning the new revision.
+	// A revision of 0 asserts that the key does not exist yet.
+	CompareAndSwap(key string, revision uint64, value T) (uint64, error)
+	// Watch streams a WatchEvent for every subsequent write to the Store until ctx is done. The channel is
+	// also closed if the watcher falls too far behind to be sent every WatchEvent, in which case it has
+	// missed writes and must Watch the Store again and List it to resync.
+	Watch(ctx context.Context) <-chan WatchEvent[T]
+}
+
+// TaskEventStore is a Store of TaskEvents which also indexes each event by the ID of the Task it belongs to.
+type TaskEventStore interface {
+	Store[*task.TaskEvent]
+	// ListByTask returns the TaskEvents recorded for the given Task ID, oldest first.
+	ListByTask(taskID string) ([]*task.TaskEvent, error)
+}
+
+// sortEvents orders TaskEvents by their Timestamp, breaking ties by ID.
+func sortEvents(events []*task.TaskEvent) {
+	sort.SliceStable(events, func(a, b int) bool {
+		if events[a].Timestamp.Equal(events[b].Timestamp) {
+			return events[a].ID.String() < events[b].ID.String()
+		}
+		return events[a].Timestamp.Before(events[b].Timestamp)
+	})
 }
 
 const (
-	MEMORY = "memory"
+	MEMORY     = "memory"
+	PERSISTENT = "persistent"
 )
+
+// ErrRevisionMismatch is returned by CompareAndSwap when the key has been written since the given revision was read.
+var ErrRevisionMismatch = errors.New("revision mismatch")
+
+// maxUpdateAttempts bounds the number of times Update retries after losing a race with another writer.
+const maxUpdateAttempts = 10
+
+// Update performs an optimistic read-modify-write of the value held at key.
+// fn receives the current value and returns the value to be written; if another writer changes
+// the key in between, the read is retried with the fresh value.
+func Update[T any](s Store[T], key string, fn func(current T) (T, error)) (T, error) {
+	var zero T
+	for i := 0; i < maxUpdateAttempts; i++ {
+		current, rev, err := s.GetWithRevision(key)
+		if err != nil {
+			return zero, err
+		}
+
+		next, err := fn(current)
+		if err != nil {
+			return zero, err
+		}
+
+		_, err = s.CompareAndSwap(key, rev, next)
+		if errors.Is(err, ErrRevisionMismatch) {
+			continue
+		}
+		if err != nil {
+			return zero, err
+		}
+		return next, nil
+	}
+	return zero, fmt.Errorf("failed to update %s after %d attempts: %w", key, maxUpdateAttempts, ErrRevisionMismatch)
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+	bolt "go.etcd.io/bbolt"
+)
+
+// TaskStore is a persistent store of Tasks held in a BoltDB file on disk.
+type TaskStore struct {
+	DB       *bolt.DB
+	DbFile   string
+	FileMode os.FileMode
+	Bucket   string
+	watchers Watchers[*task.Task]
+}
+
+// NewTaskStore opens (creating if necessary) the BoltDB file at the given path,
+// ensures the named bucket exists and returns a reference to the TaskStore.
+func NewTaskStore(file string, mode os.FileMode, bucket string) (*TaskStore, error) {
+	db, err := bolt.Open(file, mode, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+
+	t := TaskStore{
+		DB:       db,
+		DbFile:   file,
+		FileMode: mode,
+		Bucket:   bucket,
+	}
+
+	if err := t.CreateBucket(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateBucket creates the TaskStore's bucket, and the bucket tracking its revisions, if they do not already exist.
+func (t *TaskStore) CreateBucket() error {
+	return createBuckets(t.DB, t.Bucket)
+}
+
+// Close releases the underlying BoltDB file.
+func (t *TaskStore) Close() error {
+	return t.DB.Close()
+}
+
+// Get retrieves a task from the TaskStore and returns it.
+func (t *TaskStore) Get(key string) (*task.Task, error) {
+	tsk, _, err := t.GetWithRevision(key)
+	return tsk, err
+}
+
+// GetWithRevision retrieves a task from the TaskStore along with its current revision.
+func (t *TaskStore) GetWithRevision(key string) (*task.Task, uint64, error) {
+	var tsk task.Task
+	var rev uint64
+	err := t.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(t.Bucket)).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("failed to find task %s; does it exist?\n", key)
+		}
+		rev = readRevision(tx, t.Bucket, key)
+		return json.Unmarshal(v, &tsk)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &tsk, rev, nil
+}
+
+// Put inserts a key value pair into the TaskStore.
+func (t *TaskStore) Put(key string, value *task.Task) error {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task %s: %w", key, err)
+	}
+
+	var rev uint64
+	err = t.DB.Update(func(tx *bolt.Tx) error {
+		rev, err = writeRevisioned(tx, t.Bucket, key, buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	t.notifyPut(key, rev, value)
+	return nil
+}
+
+// CompareAndSwap inserts the task at key only if the key's revision still matches the given revision.
+func (t *TaskStore) CompareAndSwap(key string, revision uint64, value *task.Task) (uint64, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal task %s: %w", key, err)
+	}
+
+	var rev uint64
+	err = t.DB.Update(func(tx *bolt.Tx) error {
+		if current := readRevision(tx, t.Bucket, key); current != revision {
+			return fmt.Errorf("task %s at revision %d, expected %d: %w", key, current, revision, ErrRevisionMismatch)
+		}
+		rev, err = writeRevisioned(tx, t.Bucket, key, buf)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	t.notifyPut(key, rev, value)
+	return rev, nil
+}
+
+// notifyPut tells the TaskStore's watchers that a copy of the task was written at key.
+func (t *TaskStore) notifyPut(key string, rev uint64, value *task.Task) {
+	cpy := *value
+	t.watchers.Notify(WatchEvent[*task.Task]{Op: OpPut, Key: key, Revision: rev, Value: &cpy})
+}
+
+// Delete removes the task held at key from the TaskStore.
+func (t *TaskStore) Delete(key string) error {
+	var rev uint64
+	var old []byte
+	err := t.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		rev, old, err = deleteRevisioned(tx, t.Bucket, key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var tsk task.Task
+	if err := json.Unmarshal(old, &tsk); err != nil {
+		log.Printf("failed to unmarshal deleted task %s: %s\n", key, err)
+	}
+	t.watchers.Notify(WatchEvent[*task.Task]{Op: OpDelete, Key: key, Revision: rev, Value: &tsk})
+	return nil
+}
+
+// List decodes every task held in the TaskStore's bucket and returns them as a slice.
+func (t *TaskStore) List() ([]*task.Task, error) {
+	var taskList []*task.Task
+	err := t.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(t.Bucket))
+		return b.ForEach(func(k, v []byte) error {
+			var tsk task.Task
+			if err := json.Unmarshal(v, &tsk); err != nil {
+				return fmt.Errorf("failed to unmarshal task %s: %w", k, err)
+			}
+			taskList = append(taskList, &tsk)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return taskList, nil
+}
+
+// Query returns a page of the tasks in the TaskStore accepted by match, ordered by key.
+func (t *TaskStore) Query(match func(*task.Task) bool, cursor string, limit int) ([]*task.Task, string, error) {
+	var page []*task.Task
+	var next string
+	err := t.DB.View(func(tx *bolt.Tx) error {
+		var last []byte
+		c := tx.Bucket([]byte(t.Bucket)).Cursor()
+		k, v := c.Seek([]byte(cursor))
+		if k != nil && string(k) == cursor {
+			k, v = c.Next()
+		}
+		for ; k != nil; k, v = c.Next() {
+			var tsk task.Task
+			if err := json.Unmarshal(v, &tsk); err != nil {
+				return fmt.Errorf("failed to unmarshal task %s: %w", k, err)
+			}
+			if !match(&tsk) {
+				continue
+			}
+			if limit > 0 && len(page) == limit {
+				next = string(last)
+				return nil
+			}
+			page = append(page, &tsk)
+			last = k
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// Watch streams the writes made to the TaskStore until ctx is done.
+func (t *TaskStore) Watch(ctx context.Context) <-chan WatchEvent[*task.Task] {
+	return t.watchers.Watch(ctx)
+}
+
+// Count returns the number of tasks in the TaskStore's bucket.
+func (t *TaskStore) Count() (int, error) {
+	count := 0
+	err := t.DB.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket([]byte(t.Bucket)).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
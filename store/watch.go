@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Op identifies the kind of write a WatchEvent describes.
+type Op int
+
+const (
+	OpPut Op = iota
+	OpDelete
+)
+
+// watchBuffer is the number of WatchEvents buffered for each watcher. A watcher whose buffer is full
+// is dropped, and has its channel closed, so a slow watcher can never block writes to the Store.
+const watchBuffer = 64
+
+// WatchEvent describes a single write to a Store. For OpDelete, Value holds the value that was removed.
+type WatchEvent[T any] struct {
+	Op       Op
+	Key      string
+	Revision uint64
+	Value    T
+}
+
+// Watchers fans WatchEvents out to every active watcher of a Store. The zero value is ready to use.
+// It is exported so that Stores layered over another Store can serve their own WatchEvents.
+type Watchers[T any] struct {
+	mu   sync.Mutex
+	subs map[chan WatchEvent[T]]struct{}
+}
+
+// Watch registers a new watcher, which is removed and has its channel closed once ctx is done, or once it
+// falls watchBuffer events behind and so would miss one.
+func (w *Watchers[T]) Watch(ctx context.Context) <-chan WatchEvent[T] {
+	ch := make(chan WatchEvent[T], watchBuffer)
+
+	w.mu.Lock()
+	if w.subs == nil {
+		w.subs = make(map[chan WatchEvent[T]]struct{})
+	}
+	w.subs[ch] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		// The watcher may already have been dropped by Notify.
+		if _, ok := w.subs[ch]; ok {
+			delete(w.subs, ch)
+			close(ch)
+		}
+		w.mu.Unlock()
+	}()
+	return ch
+}
+
+// Notify sends the WatchEvent to every watcher without blocking. A watcher with no room for the WatchEvent is
+// dropped instead, and its channel closed, so that it knows it has missed writes.
+func (w *Watchers[T]) Notify(event WatchEvent[T]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.subs {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("watcher buffer full at event for %s at revision %d, dropping watcher\n", event.Key, event.Revision)
+			delete(w.subs, ch)
+			close(ch)
+		}
+	}
+}
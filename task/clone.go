@@ -0,0 +1,57 @@
+package task
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Clone returns a deep copy of the Task, which shares none of the Task's maps, slices or pointers.
+func (t *Task) Clone() *Task {
+	c := *t
+	c.Labels = maps.Clone(t.Labels)
+	c.Env = maps.Clone(t.Env)
+	c.PortBindings = maps.Clone(t.PortBindings)
+	c.ExposedPorts = maps.Clone(t.ExposedPorts)
+	if t.HostPorts != nil {
+		c.HostPorts = make(nat.PortMap, len(t.HostPorts))
+		for p, bindings := range t.HostPorts {
+			c.HostPorts[p] = slices.Clone(bindings)
+		}
+	}
+	c.Entrypoint = slices.Clone(t.Entrypoint)
+	c.Command = slices.Clone(t.Command)
+	c.Args = slices.Clone(t.Args)
+	c.Mounts = slices.Clone(t.Mounts)
+	c.PullEvents = slices.Clone(t.PullEvents)
+	c.StartupProbe = t.StartupProbe.clone()
+	c.LivenessProbe = t.LivenessProbe.clone()
+	c.ReadinessProbe = t.ReadinessProbe.clone()
+	if t.PreStop != nil {
+		h := t.PreStop.clone()
+		c.PreStop = &h
+	}
+	return &c
+}
+
+// Clone returns a deep copy of the TaskEvent; see Task.Clone.
+func (e *TaskEvent) Clone() *TaskEvent {
+	c := *e
+	c.Task = *e.Task.Clone()
+	return &c
+}
+
+func (h Hook) clone() Hook {
+	h.Exec = slices.Clone(h.Exec)
+	return h
+}
+
+func (p *Probe) clone() *Probe {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Hook = p.Hook.clone()
+	return &c
+}
@@ -0,0 +1,280 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// FakeRuntime is an in-memory Runtime which simulates container lifecycles without a container engine, so that
+// a Worker can run, and be tested, on hosts without Docker. Containers start running as soon as Run is called
+// and keep running until they are stopped, or until Exit simulates them exiting on their own.
+type FakeRuntime struct {
+	// RunError, when set, is returned by Run instead of starting a container.
+	RunError error
+	// PingError, when set, is returned by Ping, as if the container engine were unreachable.
+	PingError error
+	// ExitAfter, when non-zero, makes every container exit with ExitCode once it has run for that long.
+	ExitAfter time.Duration
+	ExitCode  int
+	// StopDelay is how long containers take to exit once asked to stop, up to the stop timeout.
+	StopDelay time.Duration
+	// Images holds the images present on the simulated host. Run adds an image to it when pulling it.
+	Images map[string]bool
+
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	nextPort   int
+}
+
+type fakeContainer struct {
+	config     Config
+	status     string
+	exitCode   int
+	hostPorts  nat.PortMap
+	startedAt  time.Time
+	finishedAt time.Time
+	stdout     []byte
+}
+
+// NewFakeRuntime creates a FakeRuntime holding no containers.
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		containers: make(map[string]*fakeContainer),
+		nextPort:   32768,
+		Images:     make(map[string]bool),
+	}
+}
+
+func (f *FakeRuntime) Run(ctx context.Context, c *Config) Result {
+	if f.RunError != nil {
+		return Result{Error: f.RunError}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now().UTC()
+	var pullEvents []PullEvent
+	switch {
+	case c.PullPolicy != PullAlways && f.Images[c.Image]:
+		pullEvents = append(pullEvents, PullEvent{Timestamp: now, Status: "Image is already present on the worker"})
+	case c.PullPolicy == PullNever:
+		err := fmt.Errorf("image %s is not present on the worker and its pull policy is %s", c.Image, PullNever)
+		pullEvents = append(pullEvents, PullEvent{Timestamp: now, Status: "Image not found", Error: err.Error()})
+		return Result{Error: err, PullEvents: pullEvents}
+	default:
+		f.Images[c.Image] = true
+		pullEvents = append(pullEvents, PullEvent{Timestamp: now, Status: "Downloaded image " + c.Image})
+	}
+
+	// Each exposed port is published on the host port it is bound to or, like Docker does for a port bound
+	// to no particular host port, on the next free one.
+	hostPorts := nat.PortMap{}
+	for p := range c.ExposedPorts {
+		var hostPort string
+		if bindings := c.PortBindings[p]; len(bindings) > 0 {
+			hostPort = bindings[0].HostPort
+		}
+		if hostPort == "" {
+			hostPort = fmt.Sprint(f.nextPort)
+			f.nextPort++
+		}
+		hostPorts[p] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}}
+	}
+
+	id := uuid.NewString()
+	f.containers[id] = &fakeContainer{
+		config:    *c,
+		status:    StatusRunning,
+		hostPorts: hostPorts,
+		startedAt: now,
+		stdout:    []byte(fmt.Sprintf("%s started %s from image %s\n", now.Format(time.RFC3339), c.Name, c.Image)),
+	}
+	return Result{ContainerID: id, Action: "start", Result: "success", PullEvents: pullEvents}
+}
+
+func (f *FakeRuntime) Stop(ctx context.Context, containerID string, opts StopOptions) Result {
+	f.mu.Lock()
+	_, ok := f.containers[containerID]
+	f.mu.Unlock()
+	if !ok {
+		return Result{Error: fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)}
+	}
+	select {
+	case <-ctx.Done():
+		return Result{Error: ctx.Err()}
+	case <-time.After(min(f.StopDelay, opts.Timeout)):
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.containers, containerID)
+	return Result{Action: "stop", Result: "success"}
+}
+
+func (f *FakeRuntime) Inspect(ctx context.Context, containerID string) InspectResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[containerID]
+	if !ok {
+		err := fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)
+		return InspectResult{ContainerID: containerID, Error: err}
+	}
+	f.expire(c)
+
+	return InspectResult{
+		ContainerID: containerID,
+		Status:      c.status,
+		ExitCode:    c.exitCode,
+		HostPorts:   c.hostPorts,
+		StartedAt:   c.startedAt,
+		FinishedAt:  c.finishedAt,
+		Labels:      c.config.Labels,
+	}
+}
+
+func (f *FakeRuntime) List(ctx context.Context, labels map[string]string) ([]InspectResult, error) {
+	f.mu.Lock()
+	var ids []string
+	for id, c := range f.containers {
+		if hasLabels(c.config.Labels, labels) {
+			ids = append(ids, id)
+		}
+	}
+	f.mu.Unlock()
+
+	results := make([]InspectResult, 0, len(ids))
+	for _, id := range ids {
+		if res := f.Inspect(ctx, id); !errors.Is(res.Error, ErrContainerNotFound) {
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+func (f *FakeRuntime) Logs(ctx context.Context, containerID string, follow bool, stdout, stderr io.Writer) error {
+	written := 0
+	for seen := false; ; seen = true {
+		f.mu.Lock()
+		c, ok := f.containers[containerID]
+		var out []byte
+		running := false
+		if ok {
+			f.expire(c)
+			out = append(out, c.stdout[written:]...)
+			running = c.status == StatusRunning
+		}
+		f.mu.Unlock()
+
+		if !ok {
+			// A container removed while being followed has no more output.
+			if seen {
+				return nil
+			}
+			return fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)
+		}
+		if _, err := stdout.Write(out); err != nil {
+			return err
+		}
+		written += len(out)
+		if !follow || !running {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+func (f *FakeRuntime) Stats(ctx context.Context, containerID string) (*ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[containerID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)
+	}
+	f.expire(c)
+
+	stats := ContainerStats{MemoryLimit: uint64(c.config.Memory)}
+	if c.status == StatusRunning {
+		stats.CPUUsage = uint64(time.Since(c.startedAt))
+		stats.PIDs = 1
+	}
+	return &stats, nil
+}
+
+// Exec simulates running a command in the container by copying stdin back to stdout, then exiting with 0.
+func (f *FakeRuntime) Exec(ctx context.Context, containerID string, cfg ExecConfig, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	f.mu.Lock()
+	c, ok := f.containers[containerID]
+	running := false
+	if ok {
+		f.expire(c)
+		running = c.status == StatusRunning
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		return -1, fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)
+	}
+	if !running {
+		return -1, fmt.Errorf("container %s is not running", containerID)
+	}
+	if stdin != nil {
+		if _, err := io.Copy(stdout, stdin); err != nil {
+			return -1, err
+		}
+	}
+	return 0, nil
+}
+
+func (f *FakeRuntime) Ping(ctx context.Context) error {
+	return f.PingError
+}
+
+func (f *FakeRuntime) Close() error {
+	return nil
+}
+
+// Exit simulates the container exiting on its own with the given exit code.
+func (f *FakeRuntime) Exit(containerID string, exitCode int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[containerID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)
+	}
+	if c.status == StatusRunning {
+		c.exit(exitCode, time.Now().UTC())
+	}
+	return nil
+}
+
+// expire exits the container if it has outlived the FakeRuntime's ExitAfter. The caller must hold f.mu.
+func (f *FakeRuntime) expire(c *fakeContainer) {
+	if f.ExitAfter == 0 || c.status != StatusRunning {
+		return
+	}
+	if deadline := c.startedAt.Add(f.ExitAfter); time.Now().After(deadline) {
+		c.exit(f.ExitCode, deadline)
+	}
+}
+
+func (c *fakeContainer) exit(exitCode int, at time.Time) {
+	c.status = StatusExited
+	c.exitCode = exitCode
+	c.finishedAt = at
+	c.stdout = append(c.stdout, []byte(fmt.Sprintf("%s exited with code %d\n", at.Format(time.RFC3339), exitCode))...)
+}
@@ -0,0 +1,146 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/distribution/reference"
+	imageTypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+)
+
+// Pull policies decide when a Worker pulls a Task's image before starting it. A Task without a PullPolicy
+// uses PullIfNotPresent.
+const (
+	PullAlways       = "Always"
+	PullIfNotPresent = "IfNotPresent"
+	PullNever        = "Never"
+)
+
+// PullEvent is a step of pulling a Task's image, such as a layer finishing downloading, or the image being
+// found already present on the Worker. Layer is empty for steps concerning the whole image.
+type PullEvent struct {
+	Timestamp time.Time
+	Layer     string `json:",omitempty"`
+	Status    string
+	Error     string `json:",omitempty"`
+}
+
+// RegistryAuth holds the credentials a Worker uses to pull images from a registry, either a Username and
+// Password or an IdentityToken.
+type RegistryAuth struct {
+	Username      string `json:",omitempty"`
+	Password      string `json:",omitempty"`
+	IdentityToken string `json:",omitempty"`
+}
+
+// LoadRegistryAuth reads a JSON file mapping registry hosts, such as "docker.io" or "ghcr.io", to the
+// RegistryAuth used to pull from them.
+func LoadRegistryAuth(path string) (map[string]RegistryAuth, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry credentials: %w", err)
+	}
+	var auth map[string]RegistryAuth
+	if err := json.Unmarshal(buf, &auth); err != nil {
+		return nil, fmt.Errorf("failed to parse registry credentials %s: %w", path, err)
+	}
+	return auth, nil
+}
+
+// Registry returns the host of the registry the image is pulled from, e.g. "docker.io" for "nginx:latest".
+func Registry(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	return reference.Domain(named), nil
+}
+
+// validatePullPolicy checks the policy is one of the Pull policies, or empty.
+func validatePullPolicy(policy string) error {
+	switch policy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+		return nil
+	}
+	return fmt.Errorf("unknown pull policy %q", policy)
+}
+
+// pullMessage is one of the JSON messages Docker streams while pulling an image.
+type pullMessage struct {
+	Status         string `json:"status"`
+	ID             string `json:"id"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+// pullImage makes sure the container's image is present, pulling it as the Config's PullPolicy requires.
+// It returns the steps taken, which are also returned alongside an error.
+func (d *Docker) pullImage(ctx context.Context) ([]PullEvent, error) {
+	image := d.Config.Image
+	if d.Config.PullPolicy != PullAlways {
+		_, _, err := d.Client.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			event := PullEvent{Timestamp: time.Now().UTC(), Status: "Image is already present on the worker"}
+			return []PullEvent{event}, nil
+		}
+		if !client.IsErrNotFound(err) {
+			return nil, fmt.Errorf("failed to inspect image %s: %w", image, err)
+		}
+		if d.Config.PullPolicy == PullNever {
+			err := fmt.Errorf("image %s is not present on the worker and its pull policy is %s", image, PullNever)
+			return []PullEvent{{Timestamp: time.Now().UTC(), Status: "Image not found", Error: err.Error()}}, err
+		}
+	}
+
+	opts := imageTypes.PullOptions{}
+	if auth := d.Config.RegistryAuth; auth != nil {
+		encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			IdentityToken: auth.IdentityToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode registry credentials: %w", err)
+		}
+		opts.RegistryAuth = encoded
+	}
+
+	reader, err := d.Client.ImagePull(ctx, image, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var events []PullEvent
+	dec := json.NewDecoder(reader)
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return events, fmt.Errorf("failed to read progress of pulling %s: %w", image, err)
+		}
+		if msg.Error != "" {
+			events = append(events, PullEvent{Timestamp: time.Now().UTC(), Layer: msg.ID, Status: "Failed", Error: msg.Error})
+			return events, fmt.Errorf("failed to pull %s: %s", image, msg.Error)
+		}
+		// Messages reporting the bytes downloaded or extracted so far are only progress bars.
+		if msg.ProgressDetail.Current != 0 || msg.ProgressDetail.Total != 0 {
+			continue
+		}
+		log.Printf("pulling %s: %s %s\n", image, msg.ID, msg.Status)
+		events = append(events, PullEvent{Timestamp: time.Now().UTC(), Layer: msg.ID, Status: msg.Status})
+	}
+}
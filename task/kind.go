@@ -0,0 +1,43 @@
+package task
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Kinds of Task. A service, the default for a Task without a Kind, is expected to keep running until it is
+// stopped, so exiting at all fails it. A batch Task runs to completion: exiting with code 0 completes it, and
+// any other code fails it.
+const (
+	KindService = "service"
+	KindBatch   = "batch"
+)
+
+// IsBatch reports whether the Task is a batch Task.
+func (t *Task) IsBatch() bool {
+	return t.Kind == KindBatch
+}
+
+// ExitState returns the State the Task is in once its container has exited with the exit code.
+func (t *Task) ExitState(exitCode int) State {
+	if t.IsBatch() && exitCode == 0 {
+		return Complete
+	}
+	return Failed
+}
+
+// validateKind checks the Task's Kind is known, and that a batch Task's container is not restarted by its
+// runtime after completing.
+func validateKind(t *Task) error {
+	switch t.Kind {
+	case "", KindService:
+		return nil
+	case KindBatch:
+		if t.RestartPolicy == container.RestartPolicyAlways || t.RestartPolicy == container.RestartPolicyUnlessStopped {
+			return fmt.Errorf("batch tasks cannot use the %q restart policy", t.RestartPolicy)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown task kind %q", t.Kind)
+}
@@ -0,0 +1,105 @@
+package task
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// Labels every container run for a Task is created with, so that its Worker can tell which Task a container
+// belongs to, and whether the Task has changed since, after restarting. LabelSpec holds the Task itself, so
+// the Worker can rebuild a Task it has forgotten; see SpecLabel.
+const (
+	LabelTaskID   = "gorcherstrator.task.id"
+	LabelWorker   = "gorcherstrator.worker"
+	LabelSpecHash = "gorcherstrator.spec.hash"
+	LabelSpec     = "gorcherstrator.spec"
+)
+
+// spec holds the parts of a Task which decide what its container runs.
+type spec struct {
+	Image         string
+	Entrypoint    []string
+	Command       []string
+	Args          []string
+	Env           map[string]string
+	WorkingDir    string
+	User          string
+	CPU           float64
+	Memory        int64
+	Disk          int64
+	ExposedPorts  nat.PortSet
+	PortBindings  map[string]string
+	Mounts        []Mount
+	RestartPolicy container.RestartPolicyMode
+	Kind          string
+}
+
+// SpecHash returns a hash of what the Task's container runs, which changes whenever the container would have
+// to be recreated to match the Task.
+func (t *Task) SpecHash() string {
+	// Maps are marshalled with sorted keys, so equal specs always hash the same.
+	buf, _ := json.Marshal(spec{
+		Image:         t.Image,
+		Entrypoint:    t.Entrypoint,
+		Command:       t.Command,
+		Args:          t.Args,
+		Env:           t.Env,
+		WorkingDir:    t.WorkingDir,
+		User:          t.User,
+		CPU:           t.CPU,
+		Memory:        t.Memory,
+		Disk:          t.Disk,
+		ExposedPorts:  t.ExposedPorts,
+		PortBindings:  t.PortBindings,
+		Mounts:        t.Mounts,
+		RestartPolicy: t.RestartPolicy,
+		Kind:          t.Kind,
+	})
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// SpecLabel returns the Task as it was asked to run, serialised for its container's LabelSpec. What the
+// Worker learns about the Task while running it, such as its State and container, is left out.
+func (t *Task) SpecLabel() string {
+	s := t.Clone()
+	s.ContainerID = ""
+	s.State = Pending
+	s.StateReason = ""
+	s.StateTime = time.Time{}
+	s.ExitCode = 0
+	s.DiskUsed = 0
+	s.HostPorts = nil
+	s.StartTime = time.Time{}
+	s.FinishTime = time.Time{}
+	s.Ready = false
+	s.PullEvents = nil
+	buf, _ := json.Marshal(s)
+	return string(buf)
+}
+
+// TaskFromLabels rebuilds the Task a container was run for from the container's labels, checking it is the
+// Task the container's LabelTaskID and LabelSpecHash name. The Task is Scheduled, as it was when the container
+// was created.
+func TaskFromLabels(labels map[string]string) (*Task, error) {
+	spec, ok := labels[LabelSpec]
+	if !ok {
+		return nil, errors.New("container has no task spec")
+	}
+	var t Task
+	if err := json.Unmarshal([]byte(spec), &t); err != nil {
+		return nil, fmt.Errorf("failed to decode task spec: %w", err)
+	}
+	if t.ID.String() != labels[LabelTaskID] || t.SpecHash() != labels[LabelSpecHash] {
+		return nil, fmt.Errorf("task spec does not match task %s", labels[LabelTaskID])
+	}
+	t.State = Scheduled
+	return &t, nil
+}
@@ -0,0 +1,114 @@
+package task
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Mount types a Task's Mounts may use.
+const (
+	MountBind   = "bind"
+	MountVolume = "volume"
+	MountTmpfs  = "tmpfs"
+)
+
+// Mount attaches storage to a Task's container at Target. Source is the host path of a bind mount or the
+// name of a volume, which Docker creates if it does not exist; tmpfs mounts have no Source and may be
+// limited to TmpfsSize bytes.
+type Mount struct {
+	Type      string
+	Source    string
+	Target    string
+	ReadOnly  bool
+	TmpfsSize int64
+}
+
+// Validate checks the Mount is well formed. It does not check a bind mount's Source is allowed on any
+// particular Worker; see AllowedHostPath.
+func (m Mount) Validate() error {
+	if !filepath.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+	}
+
+	switch m.Type {
+	case MountBind:
+		if !filepath.IsAbs(m.Source) {
+			return fmt.Errorf("bind mount source %q must be an absolute path", m.Source)
+		}
+	case MountVolume:
+		if m.Source == "" || strings.ContainsRune(m.Source, '/') {
+			return fmt.Errorf("volume mount at %s needs a volume name as its source", m.Target)
+		}
+	case MountTmpfs:
+		if m.Source != "" {
+			return fmt.Errorf("tmpfs mount at %s cannot have a source", m.Target)
+		}
+		if m.TmpfsSize < 0 {
+			return fmt.Errorf("tmpfs mount at %s has a negative size", m.Target)
+		}
+	default:
+		return fmt.Errorf("unknown mount type %q", m.Type)
+	}
+	if m.TmpfsSize != 0 && m.Type != MountTmpfs {
+		return fmt.Errorf("%s mount at %s cannot have a tmpfs size", m.Type, m.Target)
+	}
+	return nil
+}
+
+// AllowedHostPath reports whether the host path lies within one of the allowed directories. Symbolic links
+// in the path are resolved first, so a link cannot be used to escape an allowed directory.
+func AllowedHostPath(path string, allowed []string) bool {
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	for _, dir := range allowed {
+		dir = filepath.Clean(dir)
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMounts checks every Mount is well formed, and that no two share a Target.
+func validateMounts(mounts []Mount) error {
+	targets := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		target := filepath.Clean(m.Target)
+		if targets[target] {
+			return fmt.Errorf("more than one mount targets %s", target)
+		}
+		targets[target] = true
+	}
+	return nil
+}
+
+// dockerMounts converts Mounts into the mounts of a Docker container's HostConfig.
+func dockerMounts(mounts []Mount) []mount.Mount {
+	var dm []mount.Mount
+	for _, m := range mounts {
+		d := mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Type == MountTmpfs && m.TmpfsSize > 0 {
+			d.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: m.TmpfsSize}
+		}
+		dm = append(dm, d)
+	}
+	return dm
+}
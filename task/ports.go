@@ -0,0 +1,57 @@
+package task
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// PortRequests parses the Task's PortBindings, which map a container port such as "80" or "53/udp" to the
+// host port it should be published on. The host port returned for each container port is a number, or
+// empty when a dynamic port is requested by giving "" or "0".
+func (t *Task) PortRequests() (map[nat.Port]string, error) {
+	reqs := make(map[nat.Port]string, len(t.PortBindings))
+	for containerPort, hostPort := range t.PortBindings {
+		proto, port := nat.SplitProtoPort(containerPort)
+		p, err := nat.NewPort(proto, port)
+		if err != nil || p.Int() == 0 || (proto != "tcp" && proto != "udp" && proto != "sctp") {
+			return nil, fmt.Errorf("invalid container port %q", containerPort)
+		}
+
+		if hostPort == "0" {
+			hostPort = ""
+		}
+		if hostPort != "" {
+			n, err := strconv.Atoi(hostPort)
+			if err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("invalid host port %q for container port %s", hostPort, p)
+			}
+			hostPort = strconv.Itoa(n)
+		}
+		reqs[p] = hostPort
+	}
+	return reqs, nil
+}
+
+// portMap builds the bindings a Task's container is created with: each requested port, and an ephemeral
+// host port for every other exposed port, so that all of them remain reachable.
+func portMap(t *Task) (nat.PortSet, nat.PortMap) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	for p := range t.ExposedPorts {
+		exposed[p] = struct{}{}
+		bindings[p] = []nat.PortBinding{{}}
+	}
+
+	reqs, err := t.PortRequests()
+	if err != nil {
+		log.Printf("ignoring port bindings of task %s: %s\n", t.ID, err)
+	}
+	for p, hostPort := range reqs {
+		exposed[p] = struct{}{}
+		bindings[p] = []nat.PortBinding{{HostPort: hostPort}}
+	}
+	return exposed, bindings
+}
@@ -0,0 +1,89 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Defaults for the settings a Probe leaves unset.
+const (
+	DefaultProbeInterval         = 10
+	DefaultProbeTimeout          = 1
+	DefaultProbeSuccessThreshold = 1
+	DefaultProbeFailureThreshold = 3
+)
+
+// Probe is a check a Worker repeatedly makes against one of its running Tasks: the Hook's exec or HTTP
+// request, or, when TCPSocket is set, opening a connection to that container port. The probe is first made
+// InitialDelay seconds after the Task starts, then every Interval seconds, each attempt failing if it takes
+// longer than Timeout seconds. The probe passes once SuccessThreshold attempts in a row succeed, and fails
+// once FailureThreshold attempts in a row fail.
+//
+// A Task's StartupProbe is made until it first passes, and neither of its other probes is made before then.
+// Once its LivenessProbe fails, or its StartupProbe fails before passing, the Task's container is restarted.
+// The Task is Ready while its ReadinessProbe passes or, without one, once it has started. Ready is only
+// reported, and used to filter Task queries; it does not hold traffic or anything else back from the Task.
+type Probe struct {
+	Hook
+	TCPSocket        string `json:",omitempty"`
+	InitialDelay     int    `json:",omitempty"`
+	Interval         int    `json:",omitempty"`
+	Timeout          int    `json:",omitempty"`
+	SuccessThreshold int    `json:",omitempty"`
+	FailureThreshold int    `json:",omitempty"`
+}
+
+// WithDefaults returns a copy of the Probe with the defaults filled in for the settings it leaves unset.
+func (p Probe) WithDefaults() Probe {
+	if p.Interval == 0 {
+		p.Interval = DefaultProbeInterval
+	}
+	if p.Timeout == 0 {
+		p.Timeout = DefaultProbeTimeout
+	}
+	if p.SuccessThreshold == 0 {
+		p.SuccessThreshold = DefaultProbeSuccessThreshold
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = DefaultProbeFailureThreshold
+	}
+	return p
+}
+
+// Validate checks the Probe makes exactly one well formed check, with settings which are not negative.
+func (p *Probe) Validate() error {
+	if p.TCPSocket != "" {
+		if len(p.Exec) > 0 || p.HTTPGet != "" {
+			return errors.New("probe must make only one of an exec, an HTTP request or a TCP connection")
+		}
+		if _, err := nat.NewPort(nat.SplitProtoPort(p.TCPSocket)); err != nil {
+			return fmt.Errorf("invalid probe TCP port %q", p.TCPSocket)
+		}
+	} else if err := p.Hook.Validate(); err != nil {
+		return err
+	}
+	if p.InitialDelay < 0 || p.Interval < 0 || p.Timeout < 0 || p.SuccessThreshold < 0 || p.FailureThreshold < 0 {
+		return errors.New("probe settings cannot be negative")
+	}
+	return nil
+}
+
+// validateProbes checks each of the Task's probes.
+func validateProbes(t *Task) error {
+	var errs []error
+	for name, p := range map[string]*Probe{
+		"startup":   t.StartupProbe,
+		"liveness":  t.LivenessProbe,
+		"readiness": t.ReadinessProbe,
+	} {
+		if p == nil {
+			continue
+		}
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s probe: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,583 @@
+package task
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
+)
+
+// cpuPeriod is the cgroup cpu.max period, in microseconds, a Task's CPU quota is expressed against.
+const cpuPeriod = 100000
+
+// ProcessRuntime is the Runtime which runs each Task as a plain Linux process, without a container engine.
+// Every process is placed in its own cgroup v2 under CgroupRoot, where Config.Memory and Config.CPU are enforced
+// through memory.max and cpu.max. The process's stdout and stderr are written to files in LogDir.
+//
+// There are no images to run, so the process is Config.Entrypoint followed by Config.CMD. Config.Image is
+// treated as the path of the executable only when neither is set.
+type ProcessRuntime struct {
+	CgroupRoot string
+	LogDir     string
+
+	mu    sync.Mutex
+	procs map[string]*process
+	// controllers are the cgroup controllers enabled for each process's cgroup.
+	controllers map[string]bool
+}
+
+type process struct {
+	cmd        *exec.Cmd
+	credential *syscall.Credential
+	cgroup     string
+	stdout     string
+	stderr     string
+	hostPorts  nat.PortMap
+	labels     map[string]string
+	startedAt  time.Time
+	finishedAt time.Time
+	exitCode   int
+	done       chan struct{}
+}
+
+// NewProcessRuntime creates a ProcessRuntime, creating cgroupRoot and logDir if necessary and enabling the cpu,
+// memory and pids controllers for the cgroups created beneath cgroupRoot. An empty cgroupRoot selects a
+// "gorcherstrator" cgroup at the root of the host's cgroup v2 hierarchy.
+func NewProcessRuntime(cgroupRoot, logDir string) (*ProcessRuntime, error) {
+	if cgroupRoot == "" {
+		mount, err := cgroup2Mount()
+		if err != nil {
+			return nil, err
+		}
+		cgroupRoot = filepath.Join(mount, "gorcherstrator")
+	}
+
+	if err := os.MkdirAll(logDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory %s: %w", logDir, err)
+	}
+	if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", cgroupRoot, err)
+	}
+
+	// Controllers must be enabled in every ancestor's subtree_control to reach the per-process cgroups.
+	parent := filepath.Dir(cgroupRoot)
+	for _, dir := range []string{parent, cgroupRoot} {
+		available, err := readControllers(filepath.Join(dir, "cgroup.controllers"))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range []string{"cpu", "memory", "pids"} {
+			if available[c] {
+				err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0644)
+				if err != nil {
+					log.Printf("failed to enable cgroup controller %s in %s: %s\n", c, dir, err)
+				}
+			}
+		}
+	}
+
+	enabled, err := readControllers(filepath.Join(cgroupRoot, "cgroup.subtree_control"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessRuntime{
+		CgroupRoot:  cgroupRoot,
+		LogDir:      logDir,
+		procs:       make(map[string]*process),
+		controllers: enabled,
+	}, nil
+}
+
+func newProcessRuntime() (Runtime, error) {
+	p, err := NewProcessRuntime("", "process_logs")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// cgroup2Mount returns the mount point of the host's cgroup v2 hierarchy.
+func cgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return fields[1], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no cgroup v2 hierarchy is mounted")
+}
+
+func readControllers(path string) (map[string]bool, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup controllers: %w", err)
+	}
+	controllers := make(map[string]bool)
+	for _, c := range strings.Fields(string(buf)) {
+		controllers[c] = true
+	}
+	return controllers, nil
+}
+
+func (p *ProcessRuntime) Run(ctx context.Context, c *Config) Result {
+	if len(c.Mounts) > 0 {
+		return Result{Error: fmt.Errorf("cannot mount storage into %s: processes share the host's filesystem", c.Name)}
+	}
+
+	// A process binds its ports directly on the host, so it can only be published on its own port.
+	for port, bindings := range c.PortBindings {
+		for _, b := range bindings {
+			if b.HostPort != "" && b.HostPort != port.Port() {
+				err := fmt.Errorf("cannot publish port %s of %s on host port %s", port, c.Name, b.HostPort)
+				return Result{Error: err}
+			}
+		}
+	}
+
+	id := uuid.NewString()
+	cgroup := filepath.Join(p.CgroupRoot, id)
+
+	if err := os.Mkdir(cgroup, 0755); err != nil {
+		return Result{Error: fmt.Errorf("failed to create cgroup for %s: %w", c.Name, err)}
+	}
+	if err := p.limit(cgroup, c); err != nil {
+		os.Remove(cgroup)
+		return Result{Error: err}
+	}
+
+	cgroupFD, err := syscall.Open(cgroup, syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		os.Remove(cgroup)
+		return Result{Error: fmt.Errorf("failed to open cgroup for %s: %w", c.Name, err)}
+	}
+	defer syscall.Close(cgroupFD)
+
+	proc := process{
+		labels: c.Labels,
+		cgroup: cgroup,
+		stdout: filepath.Join(p.LogDir, id+".stdout"),
+		stderr: filepath.Join(p.LogDir, id+".stderr"),
+		done:   make(chan struct{}),
+	}
+	stdout, err := os.Create(proc.stdout)
+	if err != nil {
+		os.Remove(cgroup)
+		return Result{Error: fmt.Errorf("failed to create stdout file for %s: %w", c.Name, err)}
+	}
+	stderr, err := os.Create(proc.stderr)
+	if err != nil {
+		stdout.Close()
+		os.Remove(cgroup)
+		return Result{Error: fmt.Errorf("failed to create stderr file for %s: %w", c.Name, err)}
+	}
+
+	argv := append(append([]string{}, c.Entrypoint...), c.CMD...)
+	if len(argv) == 0 {
+		argv = []string{c.Image}
+	}
+	credential, err := lookupCredential(c.User)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		os.Remove(cgroup)
+		return Result{Error: err}
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	// Like a shell, the process inherits the Worker's environment, such as its PATH and HOME, which the
+	// Task's Env adds to and overrides.
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Dir = c.WorkingDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:     true,
+		UseCgroupFD: true,
+		CgroupFD:    cgroupFD,
+		Credential:  credential,
+	}
+
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		os.Remove(cgroup)
+		log.Printf("Error starting process -> %s: %v\n", argv[0], err)
+		return Result{Error: err}
+	}
+	proc.cmd = cmd
+	proc.credential = credential
+	proc.startedAt = time.Now().UTC()
+
+	proc.hostPorts = nat.PortMap{}
+	for port := range c.ExposedPorts {
+		proc.hostPorts[port] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: port.Port()}}
+	}
+
+	p.mu.Lock()
+	p.procs[id] = &proc
+	p.mu.Unlock()
+
+	go func() {
+		err := cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		proc.finishedAt = time.Now().UTC()
+		proc.exitCode = cmd.ProcessState.ExitCode()
+		if err != nil && proc.exitCode == 0 {
+			proc.exitCode = -1
+		}
+		close(proc.done)
+	}()
+
+	result := Result{ContainerID: id, Action: "start", Result: "success"}
+	if c.Disk > 0 {
+		// A process writes straight to the host's filesystem, which has no writable layer to limit.
+		result.Warnings = []string{fmt.Sprintf("disk limit of %d bytes not enforced: processes share the host's filesystem", c.Disk)}
+	}
+	return result
+}
+
+// lookupCredential resolves a user given as "name", "uid", "name:group" or "uid:gid" to the credential
+// a process is run with. An empty user runs the process as the Worker's own user.
+func lookupCredential(spec string) (*syscall.Credential, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	name, group, _ := strings.Cut(spec, ":")
+
+	u, err := user.LookupId(name)
+	if err != nil {
+		if u, err = user.Lookup(name); err != nil {
+			return nil, fmt.Errorf("failed to find user %s: %w", name, err)
+		}
+	}
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroupId(group)
+		if err != nil {
+			if g, err = user.LookupGroup(group); err != nil {
+				return nil, fmt.Errorf("failed to find group %s: %w", group, err)
+			}
+		}
+		gid = g.Gid
+	}
+
+	uidN, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid for user %s: %w", name, err)
+	}
+	gidN, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid for user %s: %w", name, err)
+	}
+	return &syscall.Credential{Uid: uint32(uidN), Gid: uint32(gidN)}, nil
+}
+
+// limit applies the Config's memory and CPU limits to the cgroup.
+func (p *ProcessRuntime) limit(cgroup string, c *Config) error {
+	if c.Memory > 0 {
+		if !p.controllers["memory"] {
+			return fmt.Errorf("cannot limit memory of %s: the cgroup memory controller is not available", c.Name)
+		}
+		err := os.WriteFile(filepath.Join(cgroup, "memory.max"), []byte(strconv.FormatInt(c.Memory, 10)), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to set memory limit of %s: %w", c.Name, err)
+		}
+	}
+
+	if c.CPU > 0 {
+		if !p.controllers["cpu"] {
+			return fmt.Errorf("cannot limit CPU of %s: the cgroup cpu controller is not available", c.Name)
+		}
+		quota := fmt.Sprintf("%d %d", int64(c.CPU*cpuPeriod), cpuPeriod)
+		if err := os.WriteFile(filepath.Join(cgroup, "cpu.max"), []byte(quota), 0644); err != nil {
+			return fmt.Errorf("failed to set CPU limit of %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Stop sends the stop signal to the process's group and, if the process has not exited within the stop
+// timeout, kills everything in its cgroup. The process's cgroup and output files are then removed.
+func (p *ProcessRuntime) Stop(ctx context.Context, id string, opts StopOptions) Result {
+	log.Printf("Stopping process: %v\n", id)
+
+	p.mu.Lock()
+	proc, ok := p.procs[id]
+	p.mu.Unlock()
+	if !ok {
+		return Result{Error: fmt.Errorf("%w: %s", ErrContainerNotFound, id)}
+	}
+
+	if sig := unix.SignalNum(opts.Signal); sig != 0 && sig != syscall.SIGKILL {
+		if err := syscall.Kill(-proc.cmd.Process.Pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			log.Printf("Error: unable to signal process -> %s: %v\n", id, err)
+		}
+		select {
+		case <-proc.done:
+		case <-ctx.Done():
+			log.Printf("stopping process %s was cancelled, killing it\n", id)
+		case <-time.After(opts.Timeout):
+			log.Printf("process %s did not exit within %s of %s, killing it\n", id, opts.Timeout, opts.Signal)
+		}
+	}
+
+	// Killing the cgroup also reaches any children the process has started outside its process group.
+	select {
+	case <-proc.done:
+	default:
+		if err := os.WriteFile(filepath.Join(proc.cgroup, "cgroup.kill"), []byte("1"), 0644); err != nil {
+			if err := syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+				log.Printf("Error: unable to kill process -> %s: %v\n", id, err)
+				return Result{Error: err}
+			}
+		}
+		<-proc.done
+	}
+
+	if err := removeCgroup(proc.cgroup); err != nil {
+		log.Printf("Error: unable to remove cgroup -> %s: %v\n", id, err)
+		return Result{Error: err}
+	}
+	os.Remove(proc.stdout)
+	os.Remove(proc.stderr)
+
+	p.mu.Lock()
+	delete(p.procs, id)
+	p.mu.Unlock()
+	return Result{Action: "stop", Result: "success"}
+}
+
+// removeCgroup removes the cgroup, retrying briefly while the kernel finishes releasing its killed processes.
+func removeCgroup(cgroup string) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(cgroup); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+func (p *ProcessRuntime) Inspect(ctx context.Context, id string) InspectResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proc, ok := p.procs[id]
+	if !ok {
+		err := fmt.Errorf("%w: %s", ErrContainerNotFound, id)
+		return InspectResult{ContainerID: id, Error: err}
+	}
+
+	res := InspectResult{
+		ContainerID: id,
+		Status:      StatusRunning,
+		HostPorts:   proc.hostPorts,
+		StartedAt:   proc.startedAt,
+		Labels:      proc.labels,
+	}
+	select {
+	case <-proc.done:
+		res.Status = StatusExited
+		res.ExitCode = proc.exitCode
+		res.FinishedAt = proc.finishedAt
+	default:
+	}
+	return res
+}
+
+// List reports the processes the Runtime has run with the labels. Processes do not outlive the Runtime which
+// ran them, so a new Runtime has none.
+func (p *ProcessRuntime) List(ctx context.Context, labels map[string]string) ([]InspectResult, error) {
+	p.mu.Lock()
+	var ids []string
+	for id, proc := range p.procs {
+		if hasLabels(proc.labels, labels) {
+			ids = append(ids, id)
+		}
+	}
+	p.mu.Unlock()
+
+	results := make([]InspectResult, 0, len(ids))
+	for _, id := range ids {
+		if res := p.Inspect(ctx, id); !errors.Is(res.Error, ErrContainerNotFound) {
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+func (p *ProcessRuntime) Logs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	p.mu.Lock()
+	proc, ok := p.procs[id]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrContainerNotFound, id)
+	}
+
+	outFile, err := os.Open(proc.stdout)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	errFile, err := os.Open(proc.stderr)
+	if err != nil {
+		return err
+	}
+	defer errFile.Close()
+
+	// When following, the files are copied from where the last copy left off until the process exits. Whether
+	// it has exited is checked before copying, so nothing it wrote before exiting is missed.
+	for {
+		exited := !follow
+		select {
+		case <-proc.done:
+			exited = true
+		default:
+		}
+
+		if _, err := io.Copy(stdout, outFile); err != nil {
+			return err
+		}
+		if _, err := io.Copy(stderr, errFile); err != nil {
+			return err
+		}
+		if exited {
+			return nil
+		}
+
+		select {
+		case <-proc.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// Exec runs the command in the process's cgroup, with the process's environment, working directory and user.
+// Processes have no terminal, so a TTY cannot be requested.
+func (p *ProcessRuntime) Exec(ctx context.Context, id string, cfg ExecConfig, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	p.mu.Lock()
+	proc, ok := p.procs[id]
+	p.mu.Unlock()
+	if !ok {
+		return -1, fmt.Errorf("%w: %s", ErrContainerNotFound, id)
+	}
+	if cfg.Tty {
+		return -1, errors.New("processes cannot be given a TTY")
+	}
+	if len(cfg.Cmd) == 0 {
+		return -1, errors.New("no command to exec")
+	}
+
+	cgroupFD, err := syscall.Open(proc.cgroup, syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open cgroup of %s: %w", id, err)
+	}
+	defer syscall.Close(cgroupFD)
+
+	cmd := exec.CommandContext(ctx, cfg.Cmd[0], cfg.Cmd[1:]...)
+	cmd.Env = proc.cmd.Env
+	cmd.Dir = proc.cmd.Dir
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// stdin may never be closed, so it is only waited on briefly once the command exits.
+	cmd.WaitDelay = time.Second
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:     true,
+		UseCgroupFD: true,
+		CgroupFD:    cgroupFD,
+		Credential:  proc.credential,
+	}
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return -1, err
+	}
+	return cmd.ProcessState.ExitCode(), nil
+}
+
+// Ping checks the cgroup the Runtime creates its processes' cgroups in is still there.
+func (p *ProcessRuntime) Ping(ctx context.Context) error {
+	if _, err := os.Stat(p.CgroupRoot); err != nil {
+		return fmt.Errorf("cgroup %s is unavailable: %w", p.CgroupRoot, err)
+	}
+	return nil
+}
+
+func (p *ProcessRuntime) Close() error {
+	return nil
+}
+
+func (p *ProcessRuntime) Stats(ctx context.Context, id string) (*ContainerStats, error) {
+	p.mu.Lock()
+	proc, ok := p.procs[id]
+	p.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, id)
+	}
+
+	var stats ContainerStats
+	cpu, err := os.ReadFile(filepath.Join(proc.cgroup, "cpu.stat"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cpu usage: %w", err)
+	}
+	for _, line := range strings.Split(string(cpu), "\n") {
+		if usec, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			v, _ := strconv.ParseUint(usec, 10, 64)
+			stats.CPUUsage = v * 1000
+		}
+	}
+
+	// The memory and pids files only exist when their controllers are enabled.
+	stats.MemoryUsage = readCgroupUint(filepath.Join(proc.cgroup, "memory.current"))
+	stats.MemoryLimit = readCgroupUint(filepath.Join(proc.cgroup, "memory.max"))
+	stats.PIDs = readCgroupUint(filepath.Join(proc.cgroup, "pids.current"))
+	return &stats, nil
+}
+
+// readCgroupUint reads a single number from a cgroup interface file, returning 0 if it is missing or "max".
+func readCgroupUint(path string) uint64 {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	return v
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package task
+
+import "errors"
+
+func newProcessRuntime() (Runtime, error) {
+	return nil, errors.New("the process runtime is only supported on Linux")
+}
@@ -0,0 +1,113 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	DOCKER  = "docker"
+	PROCESS = "process"
+	FAKE    = "fake"
+)
+
+// Container statuses reported in an InspectResult. Runtimes report them using the same names as Docker.
+const (
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+)
+
+// logPollInterval is how often a Runtime without a log stream of its own checks a followed container for
+// more output.
+const logPollInterval = 250 * time.Millisecond
+
+// ErrContainerNotFound is returned by a Runtime asked about a container it does not know of.
+var ErrContainerNotFound = errors.New("container not found")
+
+// Runtime runs Tasks in containers on a Worker's host. A Worker uses a single Runtime for all of its Tasks,
+// referring to each Task's container by the ContainerID returned from Run. Each call gives up once its context
+// is done; a container outlives the context it was run with.
+type Runtime interface {
+	// Run creates and starts a container described by the Config.
+	Run(ctx context.Context, c *Config) Result
+	// Stop stops the container as the StopOptions describe, and removes it.
+	Stop(ctx context.Context, containerID string, opts StopOptions) Result
+	// Inspect reports the current state of the container.
+	Inspect(ctx context.Context, containerID string) InspectResult
+	// List reports the current state of every container, running or not, which carries all of the labels.
+	List(ctx context.Context, labels map[string]string) ([]InspectResult, error)
+	// Logs copies the output the container has written so far to stdout and stderr and, when follow is set,
+	// keeps copying its output until the container exits.
+	Logs(ctx context.Context, containerID string, follow bool, stdout, stderr io.Writer) error
+	// Stats reports the container's current resource usage.
+	Stats(ctx context.Context, containerID string) (*ContainerStats, error)
+	// Exec runs a command inside the running container, connected to stdin, which may be nil, stdout and
+	// stderr, and returns its exit code once it exits. With a TTY, all of its output is written to stdout.
+	Exec(ctx context.Context, containerID string, cfg ExecConfig, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+	// Ping checks the Runtime can run containers, e.g. that its container engine is reachable.
+	Ping(ctx context.Context) error
+	// Close releases the Runtime's connections to its container engine. Containers are left running.
+	Close() error
+}
+
+// ExecConfig describes a command to run inside a running container.
+type ExecConfig struct {
+	Cmd []string
+	Tty bool
+}
+
+// InspectResult describes the current state of a Task's container, independent of the Runtime running it.
+type InspectResult struct {
+	ContainerID string
+	// Status is one of the Status constants, or another Runtime specific status.
+	Status     string
+	ExitCode   int
+	HostPorts  nat.PortMap
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// DiskUsage is the number of bytes the container has written to its writable layer, where the Runtime
+	// can tell.
+	DiskUsage int64
+	Labels    map[string]string
+	Error     error `json:"-"`
+}
+
+// hasLabels reports whether the labels include every one of want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainerStats is a point-in-time reading of a container's resource usage.
+type ContainerStats struct {
+	// CPUUsage is the total CPU time consumed by the container, in nanoseconds.
+	CPUUsage uint64
+	// MemoryUsage and MemoryLimit are in bytes; a MemoryLimit of 0 means the container is unlimited.
+	MemoryUsage uint64
+	MemoryLimit uint64
+	PIDs        uint64
+}
+
+// NewRuntime creates a Runtime of the given type.
+func NewRuntime(runtimeType string) (Runtime, error) {
+	switch runtimeType {
+	case DOCKER:
+		return NewDockerRuntime()
+	case PROCESS:
+		return newProcessRuntime()
+	case FAKE:
+		return NewFakeRuntime(), nil
+	default:
+		return nil, fmt.Errorf("unknown runtime type '%s'", runtimeType)
+	}
+}
@@ -1,5 +1,13 @@
 package task
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // State represents the state of a task.
 // A task can be in one of the following states:
 // Pending - task is initialising
@@ -7,6 +15,10 @@ package task
 // Running - task has successfully started running
 // Failed - task failed
 // Complete - task succeeded, finished and exited without error.
+// Stopping - task has been asked to stop and is within its grace period.
+// Restarting - task's container is being replaced, after failing a probe or health check.
+// Lost - task's container has gone, or its worker no longer knows of it.
+// Unschedulable - no worker can currently run the task; it is retried.
 type State int
 
 const (
@@ -15,18 +27,74 @@ const (
 	Running
 	Failed
 	Complete
+	Stopping
+	Restarting
+	Lost
+	Unschedulable
 )
 
+// stateNames maps each State to the name used for it in the Api.
+var stateNames = map[State]string{
+	Pending:   "pending",
+	Scheduled: "scheduled",
+	Running:   "running",
+	Failed:    "failed",
+	Complete:  "complete",
+	Stopping:  "stopping",
+
+	Restarting:    "restarting",
+	Lost:          "lost",
+	Unschedulable: "unschedulable",
+}
+
+// String returns the lower case name of the State.
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ParseState converts a State's name, or its numeric value, back into a State.
+func ParseState(name string) (State, error) {
+	for s, n := range stateNames {
+		if strings.EqualFold(n, name) {
+			return s, nil
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil {
+		if _, ok := stateNames[State(i)]; ok {
+			return State(i), nil
+		}
+	}
+	return Pending, fmt.Errorf("unknown task state %q", name)
+}
+
 // Creates mappings between the current State (key)
 // and the transitional State (values)
+//
+// A Task the Manager cannot place is Unschedulable until it can, and is Complete if stopped before then.
+// Its Worker makes it Running, or Failed if it cannot start or refuses it. A Scheduled Task may be stopped,
+// finish or be Lost before the Manager hears it is Running. A Running Task is Complete or Failed once its
+// container exits, and Stopping while being stopped. A Task is Restarting while its Worker replaces its
+// container, and is rescheduled when the Manager cannot reach the Worker to do so; it may be stopped while
+// Restarting too. A Task whose container disappears is Lost. Failed, Complete and Lost Tasks are final, so a
+// request to restart a Task which has failed meanwhile is refused.
 var stateTransitions = map[State][]State{
-	Pending:   {Scheduled},
-	Scheduled: {Scheduled, Failed, Running},
-	Running:   {Running, Failed, Complete},
-	Failed:    {},
-	Complete:  {},
+	Pending:       {Scheduled, Unschedulable, Complete},
+	Unschedulable: {Unschedulable, Scheduled, Complete},
+	Scheduled:     {Scheduled, Failed, Running, Stopping, Complete, Lost},
+	Running:       {Running, Failed, Complete, Stopping, Restarting, Lost},
+	Stopping:      {Stopping, Failed, Complete, Lost},
+	Restarting:    {Restarting, Running, Failed, Scheduled, Stopping, Lost},
+	Failed:        {},
+	Complete:      {},
+	Lost:          {},
 }
 
+// ErrInvalidTransition is returned for a change of State the state machine does not allow.
+var ErrInvalidTransition = errors.New("invalid state transition")
+
 // Includes is an auxiliary function to to determine if a given
 // state is included within a slice of states
 func Includes(states []State, state State) bool {
@@ -38,7 +106,47 @@ func Includes(states []State, state State) bool {
 	return false
 }
 
+// IsTerminal reports whether a task in the given State has finished and will not run again.
+func IsTerminal(s State) bool {
+	return s == Complete || s == Failed || s == Lost
+}
+
 // checks if a stateTransition is possible form one state to another.
 func ValidStateTransition(st State, dest State) bool {
 	return Includes(stateTransitions[st], dest)
 }
+
+// CheckTransition returns an error wrapping ErrInvalidTransition unless a task may move from st to dest.
+func CheckTransition(st State, dest State) error {
+	if !ValidStateTransition(st, dest) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, st, dest)
+	}
+	return nil
+}
+
+// Transition moves the Task to the dest State, recording the reason for the move and when it happened,
+// unless the move is not a valid transition. Staying in the same State keeps the earlier reason and time.
+// A Task moving to a terminal State without a FinishTime finishes then.
+func (t *Task) Transition(dest State, reason string) error {
+	if err := CheckTransition(t.State, dest); err != nil {
+		return err
+	}
+	if t.State != dest {
+		t.State = dest
+		t.StateReason = reason
+		t.StateTime = time.Now().UTC()
+		if IsTerminal(dest) && t.FinishTime.IsZero() {
+			t.FinishTime = t.StateTime
+		}
+	}
+	return nil
+}
+
+// CheckStop returns an error wrapping ErrInvalidTransition unless a task in State st can be stopped: moved to
+// Stopping while it runs, or straight to Complete before it has been scheduled.
+func CheckStop(st State) error {
+	if ValidStateTransition(st, Stopping) || ValidStateTransition(st, Complete) {
+		return nil
+	}
+	return fmt.Errorf("%w: a %s task cannot be stopped", ErrInvalidTransition, st)
+}
@@ -0,0 +1,92 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Defaults for stopping a Task which does not set its own StopSignal or StopTimeout.
+const (
+	DefaultStopSignal  = "SIGTERM"
+	DefaultStopTimeout = 10
+)
+
+// stopSignals are the signals a Task may be stopped with.
+var stopSignals = []string{"SIGHUP", "SIGINT", "SIGQUIT", "SIGKILL", "SIGUSR1", "SIGUSR2", "SIGTERM", "SIGWINCH"}
+
+// StopOptions describe how a Runtime stops a container: by sending it Signal, then killing it if it has not
+// exited after Timeout.
+type StopOptions struct {
+	Signal  string
+	Timeout time.Duration
+}
+
+// Hook is an action a Worker takes against a Task's container: running Exec inside it, or requesting HTTPGet,
+// a path, from the Task on Port, one of its container ports, which defaults to the first port it publishes.
+type Hook struct {
+	Exec    []string `json:",omitempty"`
+	HTTPGet string   `json:",omitempty"`
+	Port    string   `json:",omitempty"`
+}
+
+// Validate checks the Hook takes exactly one well formed action.
+func (h *Hook) Validate() error {
+	switch {
+	case len(h.Exec) > 0 && h.HTTPGet != "":
+		return errors.New("hook must either exec a command or make an HTTP request, not both")
+	case len(h.Exec) == 0 && h.HTTPGet == "":
+		return errors.New("hook must exec a command or make an HTTP request")
+	case h.HTTPGet != "" && !strings.HasPrefix(h.HTTPGet, "/"):
+		return fmt.Errorf("hook HTTP path %q must start with /", h.HTTPGet)
+	}
+	if h.Port != "" {
+		if _, err := nat.NewPort(nat.SplitProtoPort(h.Port)); err != nil {
+			return fmt.Errorf("invalid hook port %q", h.Port)
+		}
+	}
+	return nil
+}
+
+// StopOptions returns how the Task's container is stopped, applying the defaults for what the Task leaves
+// unset. The grace period is the Task's StopTimeout, in seconds.
+func (t *Task) StopOptions() StopOptions {
+	opts := StopOptions{Signal: DefaultStopSignal, Timeout: DefaultStopTimeout * time.Second}
+	if t.StopSignal != "" {
+		opts.Signal = normalizeSignal(t.StopSignal)
+	}
+	if t.StopTimeout > 0 {
+		opts.Timeout = time.Duration(t.StopTimeout) * time.Second
+	}
+	return opts
+}
+
+// normalizeSignal writes a signal name, such as "term" or "SIGTERM", the way stopSignals does.
+func normalizeSignal(name string) string {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
+
+// validateStop checks the Task's StopSignal, StopTimeout and PreStop hook.
+func validateStop(t *Task) error {
+	var errs []error
+	if t.StopSignal != "" && !slices.Contains(stopSignals, normalizeSignal(t.StopSignal)) {
+		errs = append(errs, fmt.Errorf("unknown stop signal %q", t.StopSignal))
+	}
+	if t.StopTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid stop timeout %d", t.StopTimeout))
+	}
+	if t.PreStop != nil {
+		if err := t.PreStop.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pre-stop hook: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
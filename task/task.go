@@ -2,15 +2,20 @@ package task
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math"
-	"os"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	imageTypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
@@ -20,58 +25,161 @@ import (
 // Task is the most foundational element fo the Gorchestrator.
 // A Task sits at the lowest point, under the Worker, it
 // represents all the associated properties a task possesses.
+// Entrypoint, when set, replaces the image's entrypoint, and Command followed by Args,
+// when either is set, replaces the image's default command.
+// Disk is the size, in bytes, the Task's writable layer may grow to, where the Worker's Runtime
+// can enforce it, and DiskUsed is how much of it the Worker last saw the Task using.
+// Kind is one of the Kind constants, and ExitCode is the code the Task's container exited with, once it has.
+// Stopping a Task sends its container StopSignal and allows it StopTimeout seconds to exit before it is killed,
+// after first running the PreStop hook, when set; see StopOptions. The Worker running the Task checks it with
+// its probes, when set, and reports whether it is Ready; see Probe.
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	State         State
+	ID          uuid.UUID
+	ContainerID string
+	State       State
+	// StateReason says why the Task moved to its State, at StateTime; see Transition.
+	StateReason string
+	StateTime   time.Time
+	// Generation counts the changes the Manager has asked of the Task. Its Worker reports the Task with the
+	// Generation of the latest request it has dealt with, so the Manager can tell a report made before then.
+	Generation    uint64
+	Kind          string
+	ExitCode      int
 	CPU           float64
 	Name          string
+	Labels        map[string]string
+	Worker        string
 	Disk          int64
+	DiskUsed      int64
 	Memory        int64
 	Image         string
+	PullPolicy    string
+	Entrypoint    []string
+	Command       []string
+	Args          []string
+	Env           map[string]string
+	WorkingDir    string
+	User          string
 	ExposedPorts  nat.PortSet
 	HostPorts     nat.PortMap
 	RestartPolicy container.RestartPolicyMode
 	PortBindings  map[string]string
+	Mounts        []Mount
 	StartTime     time.Time
 	FinishTime    time.Time
 	HealthCheck   string
-	RestartCount  int
+	// The probes take over from HealthCheck, which is only used when the Task has no LivenessProbe.
+	StartupProbe   *Probe
+	LivenessProbe  *Probe
+	ReadinessProbe *Probe
+	Ready          bool
+	StopSignal     string
+	StopTimeout    int
+	PreStop        *Hook
+	RestartCount   int
+	PullEvents     []PullEvent
 }
 
+// TaskEvent records a requested or observed change to a Task's State.
+// Reason describes why the event occurred, e.g. a failed health check.
 type TaskEvent struct {
 	ID        uuid.UUID
 	State     State
 	Task      Task
 	Timestamp time.Time
+	Reason    string
 }
 
 // The Config for Docker containers
 type Config struct {
-	Name          string
-	AttachStderr  bool
-	AttachStdin   bool
-	AttachStdout  bool
-	CMD           []string
-	Memory        int64
-	CPU           float64
-	Image         string
+	Name         string
+	AttachStderr bool
+	AttachStdin  bool
+	AttachStdout bool
+	CMD          []string
+	Entrypoint   []string
+	Memory       int64
+	CPU          float64
+	Image        string
+	PullPolicy   string
+	// RegistryAuth, when set, holds the credentials to pull Image with.
+	RegistryAuth  *RegistryAuth
 	Disk          int64
 	RestartPolicy container.RestartPolicyMode // ["", "always", "unless-stopped", "on-failure"]
 	Env           []string
+	WorkingDir    string
+	User          string
 	ExposedPorts  nat.PortSet
+	PortBindings  nat.PortMap
+	Mounts        []Mount
+	// Labels are set on the container, starting with the Task's LabelTaskID, LabelSpecHash and LabelSpec.
+	Labels map[string]string
 }
 
 func NewConfig(t *Task) *Config {
+	var cmd []string
+	cmd = append(cmd, t.Command...)
+	cmd = append(cmd, t.Args...)
+
+	// Env is sorted so the same Task always produces the same Config.
+	env := make([]string, 0, len(t.Env))
+	for k, v := range t.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(env)
+
+	exposed, bindings := portMap(t)
+
+	// The container's output is attached, so the Worker can capture it into the Task's logs.
 	return &Config{
 		Name:          t.Name,
+		AttachStdout:  true,
+		AttachStderr:  true,
+		CMD:           cmd,
+		Entrypoint:    t.Entrypoint,
 		CPU:           t.CPU,
 		Memory:        t.Memory,
 		Image:         t.Image,
+		PullPolicy:    t.PullPolicy,
 		Disk:          t.Disk,
 		RestartPolicy: t.RestartPolicy,
-		ExposedPorts:  t.ExposedPorts,
+		Env:           env,
+		WorkingDir:    t.WorkingDir,
+		User:          t.User,
+		ExposedPorts:  exposed,
+		PortBindings:  bindings,
+		Mounts:        t.Mounts,
+		Labels: map[string]string{
+			LabelTaskID:   t.ID.String(),
+			LabelSpecHash: t.SpecHash(),
+			LabelSpec:     t.SpecLabel(),
+		},
+	}
+}
+
+// Validate checks the parts of a Task a Runtime cannot otherwise make sense of: its PortBindings, Mounts,
+// PullPolicy, Kind, probes and how it is stopped.
+func (t *Task) Validate() error {
+	var errs []error
+	if _, err := t.PortRequests(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateMounts(t.Mounts); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validatePullPolicy(t.PullPolicy); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateKind(t); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateStop(t); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateProbes(t); err != nil {
+		errs = append(errs, err)
 	}
+	return errors.Join(errs...)
 }
 
 // Docker encapsulates all the data needed to run Tasks inside
@@ -81,21 +189,17 @@ type Docker struct {
 	Config Config
 }
 
-// Creates a NewDocker clients with options.
-func NewDocker(c *Config) *Docker {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
-	return &Docker{
-		Client: dc,
-		Config: *c,
-	}
-}
-
-// DockerResult provides an API wrapper for the interactions with a Docker Container.
-type DockerResult struct {
+// Result provides an API wrapper for the interactions with a Task's container.
+type Result struct {
 	ContainerID string
 	Action      string
 	Result      string
 	Error       error
+	// PullEvents are the steps taken to pull the image of a container being run.
+	PullEvents []PullEvent
+	// Warnings describe the parts of a container's Config the Runtime ran it without, such as a Disk limit
+	// it cannot enforce.
+	Warnings []string
 }
 
 // DockerInspectResponse provides insight into the State of a running Docker container.
@@ -104,18 +208,12 @@ type DockerInspectResponse struct {
 	Error     error
 }
 
-func (d *Docker) Run() DockerResult {
-	ctx := context.Background()
-	reader, err := d.Client.ImagePull(
-		ctx, d.Config.Image, imageTypes.PullOptions{},
-	)
+// Run pulls the container's image as its pull policy requires, then creates and starts the container.
+func (d *Docker) Run(ctx context.Context) Result {
+	pullEvents, err := d.pullImage(ctx)
 	if err != nil {
 		log.Printf("Error, unable to pull image-> %s: %v\n", d.Config.Image, err)
-		return DockerResult{Error: err}
-	}
-	_, cpyErr := io.Copy(os.Stdout, reader)
-	if cpyErr != nil && cpyErr != io.EOF {
-		log.Printf("error copying reader: %s\n", cpyErr)
+		return Result{Error: err, PullEvents: pullEvents}
 	}
 
 	restartPolicy := container.RestartPolicy{
@@ -129,16 +227,28 @@ func (d *Docker) Run() DockerResult {
 	}
 
 	hostConfig := container.HostConfig{
-		RestartPolicy:   restartPolicy,
-		Resources:       resources,
-		PublishAllPorts: true,
+		RestartPolicy: restartPolicy,
+		Resources:     resources,
+		PortBindings:  d.Config.PortBindings,
+		Mounts:        dockerMounts(d.Config.Mounts),
+	}
+	if d.Config.Disk > 0 {
+		hostConfig.StorageOpt = map[string]string{"size": strconv.FormatInt(d.Config.Disk, 10)}
 	}
 
 	containerConfig := container.Config{
 		Image:        d.Config.Image,
+		AttachStdin:  d.Config.AttachStdin,
+		AttachStdout: d.Config.AttachStdout,
+		AttachStderr: d.Config.AttachStderr,
 		Tty:          false,
+		Cmd:          d.Config.CMD,
+		Entrypoint:   d.Config.Entrypoint,
 		Env:          d.Config.Env,
+		WorkingDir:   d.Config.WorkingDir,
+		User:         d.Config.User,
 		ExposedPorts: d.Config.ExposedPorts,
+		Labels:       d.Config.Labels,
 	}
 
 	resp, err := d.Client.ContainerCreate(
@@ -151,34 +261,33 @@ func (d *Docker) Run() DockerResult {
 	)
 	if err != nil {
 		log.Printf("Erro starting Docker Container -> %s: %v", d.Config.Image, err)
-		return DockerResult{Error: err}
+		return Result{Error: err, PullEvents: pullEvents}
 	}
 
-	out, err := d.Client.ContainerLogs(
-		ctx,
-		resp.ID,
-		container.LogsOptions{
-			ShowStdout: true,
-			ShowStderr: true,
-		})
-	if err != nil {
-		log.Printf("error obtaining ContainerLogs: %s\n", err)
+	if err := d.Client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		log.Printf("Error starting Docker Container -> %s: %v", resp.ID, err)
+		// The container was created but will never run, so it is removed rather than left behind. ctx may be
+		// why it failed to start, so the removal is not bound by it.
+		removeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if rmErr := d.Client.ContainerRemove(removeCtx, resp.ID, container.RemoveOptions{Force: true, RemoveVolumes: true}); rmErr != nil {
+			log.Printf("Error: unable to remove container which failed to start -> %s: %v\n", resp.ID, rmErr)
+			return Result{ContainerID: resp.ID, Error: err, PullEvents: pullEvents}
+		}
+		return Result{Error: err, PullEvents: pullEvents}
 	}
-	_, stdCpyErr := stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-	if stdCpyErr != nil && stdCpyErr != io.EOF {
-		log.Printf("error copying data: %s\n", stdCpyErr)
-	}
-	return DockerResult{ContainerID: resp.ID, Action: "start", Result: "success"}
+	return Result{ContainerID: resp.ID, Action: "start", Result: "success", PullEvents: pullEvents}
 }
 
-func (d *Docker) Stop(id string) DockerResult {
+// Stop sends the container the stop signal and, if it has not exited within the stop timeout, kills it.
+// The container is then removed.
+func (d *Docker) Stop(ctx context.Context, id string, opts StopOptions) Result {
 	log.Printf("Stopping container: %v\n", id)
 
-	ctx := context.Background()
-	noWaitTimeout := 0
-	if err := d.Client.ContainerStop(ctx, id, container.StopOptions{Timeout: &noWaitTimeout}); err != nil {
+	timeout := int(opts.Timeout.Seconds())
+	if err := d.Client.ContainerStop(ctx, id, container.StopOptions{Signal: opts.Signal, Timeout: &timeout}); err != nil {
 		log.Printf("Error: unable to stop container -> %s: %v\n", id, err)
-		return DockerResult{Error: err}
+		return Result{Error: err}
 	}
 
 	if err := d.Client.ContainerRemove(ctx, id, container.RemoveOptions{
@@ -187,20 +296,328 @@ func (d *Docker) Stop(id string) DockerResult {
 		RemoveLinks:   false,
 	}); err != nil {
 		log.Printf("Error: unable to remove container -> %s: %v\n", id, err)
-		return DockerResult{Error: err}
+		return Result{Error: err}
 	}
 
-	return DockerResult{Action: "stop", Result: "success", Error: nil}
+	return Result{Action: "stop", Result: "success", Error: nil}
 }
 
 // Inspect runs and returns the result of a Docker container inspection on the given containerID, giving insight into the current state of the given container.
-func (d *Docker) Inspect(containerID string) DockerInspectResponse {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
-	ctx := context.Background()
-	res, err := dc.ContainerInspect(ctx, containerID)
+func (d *Docker) Inspect(ctx context.Context, containerID string) DockerInspectResponse {
+	res, err := d.Client.ContainerInspect(ctx, containerID)
 	if err != nil {
 		log.Printf("failed to inspect container: %s\n", err)
+		return DockerInspectResponse{Error: err}
 	}
 
 	return DockerInspectResponse{Container: &res}
 }
+
+// DiskUsage returns the size of the container's writable layer. Docker has to walk the layer to size it,
+// so it is much slower than an Inspect.
+func (d *Docker) DiskUsage(ctx context.Context, containerID string) (int64, error) {
+	res, _, err := d.Client.ContainerInspectWithRaw(ctx, containerID, true)
+	if err != nil {
+		return 0, err
+	}
+	if res.SizeRw == nil {
+		return 0, nil
+	}
+	return *res.SizeRw, nil
+}
+
+// Logs copies the stdout and stderr the container has written so far to the given writers and, when follow
+// is set, keeps copying until the container exits.
+func (d *Docker) Logs(ctx context.Context, containerID string, follow bool, stdout, stderr io.Writer) error {
+	out, err := d.Client.ContainerLogs(
+		ctx,
+		containerID,
+		container.LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     follow,
+		})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, out); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Stats takes a single reading of the container's resource usage.
+func (d *Docker) Stats(ctx context.Context, containerID string) (*ContainerStats, error) {
+	res, err := d.Client.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var s types.StatsJSON
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+
+	return &ContainerStats{
+		CPUUsage:    s.CPUStats.CPUUsage.TotalUsage,
+		MemoryUsage: s.MemoryStats.Usage,
+		MemoryLimit: s.MemoryStats.Limit,
+		PIDs:        s.PidsStats.Current,
+	}, nil
+}
+
+// Exec runs a command inside the running container, and returns its exit code once it exits.
+func (d *Docker) Exec(ctx context.Context, containerID string, cfg ExecConfig, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	created, err := d.Client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          cfg.Cmd,
+		Tty:          cfg.Tty,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	hr, err := d.Client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: cfg.Tty})
+	if err != nil {
+		return -1, err
+	}
+	defer hr.Close()
+
+	if stdin != nil {
+		go func() {
+			if _, err := io.Copy(hr.Conn, stdin); err != nil {
+				log.Printf("failed to copy stdin to exec %s: %s\n", created.ID, err)
+			}
+			hr.CloseWrite()
+		}()
+	}
+
+	// Without a TTY, Docker multiplexes stdout and stderr onto the one stream.
+	if cfg.Tty {
+		_, err = io.Copy(stdout, hr.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, hr.Reader)
+	}
+	if err != nil && err != io.EOF {
+		return -1, err
+	}
+
+	res, err := d.Client.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return -1, err
+	}
+	return res.ExitCode, nil
+}
+
+// diskUsageInterval is how often a DockerRuntime sizes the writable layer of each running container.
+const diskUsageInterval = 5 * time.Minute
+
+// DockerRuntime is the Runtime which runs Tasks in Docker containers, sharing one Docker client between all
+// of them.
+type DockerRuntime struct {
+	Client *client.Client
+
+	// diskUsage holds the size of each running container's writable layer, as last measured.
+	mu        sync.Mutex
+	diskUsage map[string]diskUsage
+	// diskLimits is why the daemon's storage driver cannot limit the size of a writable layer, or nil if it
+	// can, once diskLimitsChecked.
+	diskLimits        error
+	diskLimitsChecked bool
+}
+
+type diskUsage struct {
+	bytes int64
+	at    time.Time
+}
+
+// NewDockerRuntime creates a DockerRuntime whose client is configured from the environment, e.g. DOCKER_HOST,
+// and negotiates the API version with the Docker daemon.
+func NewDockerRuntime() (*DockerRuntime, error) {
+	dc, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	return &DockerRuntime{Client: dc}, nil
+}
+
+// docker returns a Docker using the DockerRuntime's client for a container described by the Config.
+func (r *DockerRuntime) docker(c *Config) *Docker {
+	return &Docker{Client: r.Client, Config: *c}
+}
+
+// Run runs the container. Only some storage drivers can limit the size of a container's writable layer; on
+// the others a container with a Disk limit is run without it, which the Result's Warnings say.
+func (r *DockerRuntime) Run(ctx context.Context, c *Config) Result {
+	d := r.docker(c)
+	if c.Disk <= 0 {
+		return d.Run(ctx)
+	}
+	unsupported, err := r.checkDiskLimits(ctx)
+	if err != nil {
+		return Result{Error: err}
+	}
+	if unsupported == nil {
+		return d.Run(ctx)
+	}
+	d.Config.Disk = 0
+	result := d.Run(ctx)
+	result.Warnings = append(result.Warnings, fmt.Sprintf("disk limit of %d bytes not enforced: %s", c.Disk, unsupported))
+	return result
+}
+
+// checkDiskLimits returns why the Docker daemon's storage driver cannot limit the size of a container's
+// writable layer, or nil if it can. The daemon's storage driver is only looked up once, the first time the
+// DockerRuntime runs a container with a Disk limit; the error is set if it cannot be looked up.
+func (r *DockerRuntime) checkDiskLimits(ctx context.Context) (unsupported, err error) {
+	r.mu.Lock()
+	checked, unsupported := r.diskLimitsChecked, r.diskLimits
+	r.mu.Unlock()
+	if checked {
+		return unsupported, nil
+	}
+
+	info, err := r.Client.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the docker storage driver: %w", err)
+	}
+	unsupported = storageDriverLimits(info.Driver, info.DriverStatus)
+	if unsupported != nil {
+		log.Printf("containers will run without disk limits: %s\n", unsupported)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.diskLimits, r.diskLimitsChecked = unsupported, true
+	return unsupported, nil
+}
+
+// storageDriverLimits returns why the storage driver, with the status Docker reports for it, cannot limit the
+// size of a container's writable layer, or nil if it can. overlay2 only can on xfs, and then only where the
+// filesystem is mounted with project quotas, which Docker does not report; without them, creating a container
+// with a limit fails.
+func storageDriverLimits(driver string, status [][2]string) error {
+	switch driver {
+	case "btrfs", "devicemapper", "windowsfilter", "zfs":
+		return nil
+	case "overlay2":
+		for _, s := range status {
+			if s[0] == "Backing Filesystem" && s[1] != "xfs" {
+				return fmt.Errorf("storage driver overlay2 cannot limit a container's size on %s, only on xfs", s[1])
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("storage driver %s cannot limit a container's size", driver)
+}
+
+func (r *DockerRuntime) Stop(ctx context.Context, containerID string, opts StopOptions) Result {
+	r.mu.Lock()
+	delete(r.diskUsage, containerID)
+	r.mu.Unlock()
+	return r.docker(&Config{}).Stop(ctx, containerID, opts)
+}
+
+func (r *DockerRuntime) Inspect(ctx context.Context, containerID string) InspectResult {
+	res := r.docker(&Config{}).Inspect(ctx, containerID)
+	if res.Error != nil {
+		if client.IsErrNotFound(res.Error) {
+			err := fmt.Errorf("%w: %s", ErrContainerNotFound, containerID)
+			return InspectResult{ContainerID: containerID, Error: err}
+		}
+		return InspectResult{ContainerID: containerID, Error: res.Error}
+	}
+
+	result := InspectResult{ContainerID: containerID}
+	if state := res.Container.State; state != nil {
+		result.Status = state.Status
+		result.ExitCode = state.ExitCode
+		result.StartedAt, _ = time.Parse(time.RFC3339Nano, state.StartedAt)
+		result.FinishedAt, _ = time.Parse(time.RFC3339Nano, state.FinishedAt)
+	}
+	if res.Container.NetworkSettings != nil {
+		result.HostPorts = res.Container.NetworkSettings.Ports
+	}
+	if result.Status == StatusRunning {
+		result.DiskUsage = r.measureDisk(ctx, containerID)
+	}
+	if res.Container.Config != nil {
+		result.Labels = res.Container.Config.Labels
+	}
+	return result
+}
+
+// measureDisk returns the size of the running container's writable layer, measuring it again only once the
+// last measurement is diskUsageInterval old.
+func (r *DockerRuntime) measureDisk(ctx context.Context, containerID string) int64 {
+	r.mu.Lock()
+	last, ok := r.diskUsage[containerID]
+	r.mu.Unlock()
+	if ok && time.Since(last.at) < diskUsageInterval {
+		return last.bytes
+	}
+
+	bytes, err := r.docker(&Config{}).DiskUsage(ctx, containerID)
+	if err != nil {
+		log.Printf("failed to measure disk usage of container %s: %s\n", containerID, err)
+		return last.bytes
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.diskUsage == nil {
+		r.diskUsage = make(map[string]diskUsage)
+	}
+	r.diskUsage[containerID] = diskUsage{bytes: bytes, at: time.Now()}
+	return bytes
+}
+
+// List finds the containers carrying the labels, including stopped ones, and inspects each of them.
+func (r *DockerRuntime) List(ctx context.Context, labels map[string]string) ([]InspectResult, error) {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", k+"="+v)
+	}
+	containers, err := r.Client.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]InspectResult, 0, len(containers))
+	for _, c := range containers {
+		// A container may be removed between being listed and inspected.
+		if res := r.Inspect(ctx, c.ID); !errors.Is(res.Error, ErrContainerNotFound) {
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+func (r *DockerRuntime) Logs(ctx context.Context, containerID string, follow bool, stdout, stderr io.Writer) error {
+	return r.docker(&Config{}).Logs(ctx, containerID, follow, stdout, stderr)
+}
+
+func (r *DockerRuntime) Stats(ctx context.Context, containerID string) (*ContainerStats, error) {
+	return r.docker(&Config{}).Stats(ctx, containerID)
+}
+
+func (r *DockerRuntime) Exec(ctx context.Context, containerID string, cfg ExecConfig, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return r.docker(&Config{}).Exec(ctx, containerID, cfg, stdin, stdout, stderr)
+}
+
+// Ping checks the Docker daemon is reachable.
+func (r *DockerRuntime) Ping(ctx context.Context) error {
+	if _, err := r.Client.Ping(ctx); err != nil {
+		return fmt.Errorf("docker daemon is unreachable: %w", err)
+	}
+	return nil
+}
+
+func (r *DockerRuntime) Close() error {
+	return r.Client.Close()
+}
@@ -0,0 +1,136 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Log is an append-only write-ahead log of JSON encoded records, one per line.
+// Every record is synced to disk before Append returns.
+type Log struct {
+	Path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens (creating if necessary) the write-ahead log at the given path.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log %s: %w", path, err)
+	}
+	return &Log{Path: path, file: f}, nil
+}
+
+// Append encodes the record and durably appends it to the Log.
+func (l *Log) Append(record any) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("failed to append to %s: %w", l.Path, err)
+	}
+	return l.file.Sync()
+}
+
+// Replay calls fn with every record in the Log, oldest first. A partially written final record,
+// left behind by a crash mid-append, is discarded and truncated from the Log.
+func (l *Log) Replay(fn func(record json.RawMessage) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var valid int64
+	r := bufio.NewReader(l.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("discarding torn record at the end of %s\n", l.Path)
+				return l.file.Truncate(valid)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", l.Path, err)
+		}
+
+		if err := fn(json.RawMessage(line)); err != nil {
+			return err
+		}
+		valid += int64(len(line))
+	}
+}
+
+// Truncate discards every record in the Log, typically once they are covered by a snapshot.
+func (l *Log) Truncate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", l.Path, err)
+	}
+	return l.file.Sync()
+}
+
+// Close closes the Log's file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// WriteSnapshot atomically replaces the snapshot at path with the JSON encoding of state.
+func WriteSnapshot(path string, state any) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshot decodes the snapshot at path into state. It reports false if no snapshot has been written yet.
+func ReadSnapshot(path string, state any) (bool, error) {
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read snapshot %s: %w", path, err)
+	}
+	if err := json.Unmarshal(buf, state); err != nil {
+		return false, fmt.Errorf("failed to unmarshal snapshot %s: %w", path, err)
+	}
+	return true, nil
+}
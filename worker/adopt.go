@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// Orphan policies decide what a Worker does with a container labelled as its own which belongs to none of
+// its Tasks. New gives a Worker with a persistent DB OrphanRemove and any other OrphanKeep, since a Worker
+// which forgets its Tasks when it restarts cannot be sure a container is not one of theirs. A Worker without
+// an OrphanPolicy uses OrphanRemove.
+const (
+	// OrphanRemove stops and removes the container.
+	OrphanRemove = "remove"
+	// OrphanKeep leaves the container as it is.
+	OrphanKeep = "keep"
+)
+
+// AdoptReport lists the containers AdoptContainers adopted, and those it found orphaned.
+type AdoptReport struct {
+	Adopted  []string
+	Orphaned []string
+}
+
+func (r AdoptReport) String() string {
+	return fmt.Sprintf("adopted %d containers, found %d orphaned", len(r.Adopted), len(r.Orphaned))
+}
+
+// AdoptContainers rebuilds the Worker's DB from the containers its Runtime holds which are labelled with the
+// Worker's name, so that a restarted Worker picks up the Tasks it was running. A container is adopted by its
+// Task when the Task has not finished, is not already running in another container and has not changed since
+// the container was created. A Task missing from the DB, such as when the DB is kept in memory, is rebuilt
+// from the container's LabelSpec. Every other container is an orphan, and is dealt with according to
+// the Worker's OrphanPolicy. AdoptContainers is meant to be called before the Worker starts processing Tasks.
+func (w *Worker) AdoptContainers() (AdoptReport, error) {
+	var report AdoptReport
+	ctx, cancel := context.WithTimeout(w.ctx, inspectTimeout)
+	containers, err := w.Runtime.List(ctx, map[string]string{task.LabelWorker: w.Name})
+	cancel()
+	if err != nil {
+		return report, fmt.Errorf("failed to list containers of worker %s: %w", w.Name, err)
+	}
+
+	for _, c := range containers {
+		if reason := w.adopt(c); reason != "" {
+			log.Printf("container %s is orphaned: %s\n", c.ContainerID, reason)
+			report.Orphaned = append(report.Orphaned, c.ContainerID)
+			w.reap(c)
+			continue
+		}
+		log.Printf("adopted container %s for task %s\n", c.ContainerID, c.Labels[task.LabelTaskID])
+		report.Adopted = append(report.Adopted, c.ContainerID)
+	}
+	return report, nil
+}
+
+// adopt gives the container back to its Task, updating the Task with the container's state. It returns why
+// the container cannot be adopted, if it cannot.
+func (w *Worker) adopt(c task.InspectResult) string {
+	taskID := c.Labels[task.LabelTaskID]
+	t, err := w.DB.Get(taskID)
+	if err != nil {
+		if t, err = task.TaskFromLabels(c.Labels); err != nil {
+			return fmt.Sprintf("no task %q is known and it cannot be rebuilt: %s", taskID, err)
+		}
+		log.Printf("rebuilt task %s from container %s\n", taskID, c.ContainerID)
+	}
+	switch {
+	case task.IsTerminal(t.State):
+		return fmt.Sprintf("task %s is %s", taskID, t.State)
+	case t.ContainerID != "" && t.ContainerID != c.ContainerID:
+		return fmt.Sprintf("task %s runs in container %s", taskID, t.ContainerID)
+	case c.Labels[task.LabelSpecHash] != t.SpecHash():
+		return fmt.Sprintf("task %s has changed since the container was created", taskID)
+	}
+
+	stopping := t.State == task.Stopping
+	t.ContainerID = c.ContainerID
+	t.HostPorts = c.HostPorts
+	t.DiskUsed = c.DiskUsage
+	if !c.StartedAt.IsZero() {
+		t.StartTime = c.StartedAt
+	}
+	running := c.Status != task.StatusExited
+	// The adopted container's probes start over, as though it had just started.
+	t.Ready = running && !stopping && t.StartupProbe == nil && t.ReadinessProbe == nil
+	if !stopping {
+		if err := t.Transition(task.Running, fmt.Sprintf("container %s adopted by worker %s", c.ContainerID, w.Name)); err != nil {
+			return err.Error()
+		}
+	}
+	if !running {
+		if err := t.Transition(t.ExitState(c.ExitCode), fmt.Sprintf("container exited with code %d", c.ExitCode)); err != nil {
+			return err.Error()
+		}
+		t.ExitCode = c.ExitCode
+		t.FinishTime = c.FinishedAt
+		if t.FinishTime.IsZero() {
+			t.FinishTime = time.Now().UTC()
+		}
+	}
+	if err := w.DB.Put(t.ID.String(), t); err != nil {
+		return fmt.Sprintf("failed to update task %s: %s", taskID, err)
+	}
+
+	if running {
+		go w.Logs.Capture(w.ctx, taskID, w.Runtime, c.ContainerID)
+	}
+	if running && !stopping {
+		w.startProbes(*t)
+	}
+	// A Task which was being stopped when the Worker went away is stopped again.
+	if running && stopping {
+		go w.StopTask(*t)
+	}
+	return ""
+}
+
+// reap deals with an orphaned container as the Worker's OrphanPolicy requires.
+func (w *Worker) reap(c task.InspectResult) {
+	if w.OrphanPolicy == OrphanKeep {
+		return
+	}
+	opts := task.StopOptions{Signal: task.DefaultStopSignal, Timeout: task.DefaultStopTimeout * time.Second}
+	ctx, cancel := context.WithTimeout(w.ctx, opts.Timeout+stopTimeout)
+	defer cancel()
+	if res := w.Runtime.Stop(ctx, c.ContainerID, opts); res.Error != nil {
+		log.Printf("failed to remove orphaned container %s: %s\n", c.ContainerID, res.Error)
+	}
+}
@@ -17,6 +17,8 @@ type Api struct {
 	Router  *http.ServeMux
 	Worker  *Worker
 	Port    int
+	// ExecToken is the bearer token authorizing requests to exec into Tasks. Exec is disabled when it is empty.
+	ExecToken string
 }
 
 // initRouter initialises the Api Router setting up the necessary routes in the process.
@@ -25,7 +27,10 @@ func (a *Api) initRouter() {
 	a.Router.HandleFunc("POST /tasks", a.StartTaskHandler)
 	a.Router.HandleFunc("GET /tasks", a.GetTaskHandler)
 	a.Router.HandleFunc("DELETE /tasks/{taskID}", a.StopTaskHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/logs", a.GetTaskLogsHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/exec", a.ExecTaskHandler)
 	a.Router.HandleFunc("GET /stats", a.GetStatsHandler)
+	a.Router.HandleFunc("GET /ready", a.GetReadyHandler)
 }
 
 // Starts the server and invokes the initRouter ensuring the routes are established.
@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// An exec runs a command inside one of a Worker's running Tasks over an upgraded HTTP connection. The client
+// POSTs an ExecRequest to /tasks/{taskID}/exec with the "Connection: Upgrade" and "Upgrade: tcp" headers, and
+// a bearer token in the Authorization header. Once the server has answered "101 Switching Protocols", what
+// the client writes is the command's stdin, until it closes its side of the connection, and what it reads
+// is the command's output in frames: an 8 byte header, holding the stream the frame belongs to in its first
+// byte and the frame's length as a big endian uint32 in its last 4, followed by the frame's data. Frames for
+// stream 1 carry stdout, those for 2 stderr, and the last frame, on stream 3, carries the ExecResult as JSON.
+const (
+	// ExecUpgrade is the protocol an exec's connection is upgraded to.
+	ExecUpgrade = "tcp"
+	// ExecStreamContentType is the content type of an exec's output stream.
+	ExecStreamContentType = "application/vnd.docker.multiplexed-stream"
+)
+
+// ExecRequest is the command to run in a Task. With a TTY, the command's output is all sent on stdout.
+type ExecRequest struct {
+	Cmd []string
+	Tty bool
+}
+
+// ExecResult reports how an exec ended: the command's exit code, or why it could not be run.
+type ExecResult struct {
+	ExitCode int
+	Error    string `json:",omitempty"`
+}
+
+// AuthorizeExec checks the request carries the bearer token. An empty token disables exec altogether.
+func AuthorizeExec(r *http.Request, token string) (int, error) {
+	if token == "" {
+		return http.StatusForbidden, errors.New("exec is disabled")
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return http.StatusUnauthorized, errors.New("exec requires a valid bearer token")
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), ExecUpgrade) {
+		return http.StatusBadRequest, fmt.Errorf("exec requires upgrading the connection to %q", ExecUpgrade)
+	}
+	return 0, nil
+}
+
+// ExecTask runs the command in the Task, reading its stdin from in and writing its output to out as frames.
+func (w *Worker) ExecTask(t task.Task, req ExecRequest, in io.Reader, out io.Writer) ExecResult {
+	var mu sync.Mutex
+	stdout := &frameWriter{mu: &mu, w: stdcopy.NewStdWriter(out, stdcopy.Stdout)}
+	stderr := &frameWriter{mu: &mu, w: stdcopy.NewStdWriter(out, stdcopy.Stderr)}
+
+	cfg := task.ExecConfig{Cmd: req.Cmd, Tty: req.Tty}
+	code, err := w.Runtime.Exec(w.ctx, t.ContainerID, cfg, in, stdout, stderr)
+	if err != nil {
+		return ExecResult{ExitCode: -1, Error: err.Error()}
+	}
+	return ExecResult{ExitCode: code}
+}
+
+// frameWriter writes to one of an exec's output streams. Writes to its streams may come from several
+// goroutines, so they share a lock.
+type frameWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.w.Write(p)
+}
+
+// writeExecResult writes the frame ending an exec's output.
+func writeExecResult(w io.Writer, res ExecResult) error {
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = stdcopy.NewStdWriter(w, stdcopy.Systemerr).Write(buf)
+	return err
+}
+
+// Exec runs the command in a Task through the Api at addr, which is either a Worker's or a Manager's,
+// authorizing with the token. It copies stdin to the command until stdin is exhausted, and the command's
+// output to stdout and stderr, and returns the command's exit code.
+func Exec(addr, taskID, token string, req ExecRequest, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return -1, err
+	}
+	httpReq, err := http.NewRequest("POST", fmt.Sprintf("http://%s/tasks/%s/exec", addr, taskID), bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Connection", "Upgrade")
+	httpReq.Header.Set("Upgrade", ExecUpgrade)
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return -1, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if err := httpReq.Write(conn); err != nil {
+		return -1, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, httpReq)
+	if err != nil {
+		return -1, fmt.Errorf("failed to read exec response: %w", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		defer res.Body.Close()
+		e := ApiErrorResponse{}
+		if err := json.NewDecoder(res.Body).Decode(&e); err == nil && e.Message != "" {
+			return -1, fmt.Errorf("exec failed with StatusCode %d: %s", res.StatusCode, strings.TrimSpace(e.Message))
+		}
+		return -1, fmt.Errorf("exec failed with StatusCode %d", res.StatusCode)
+	}
+
+	if stdin != nil {
+		go func() {
+			io.Copy(conn, stdin)
+			if c, ok := conn.(interface{ CloseWrite() error }); ok {
+				c.CloseWrite()
+			}
+		}()
+	} else if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return -1, fmt.Errorf("exec stream ended without a result: %w", err)
+		}
+		frame := io.LimitReader(br, int64(binary.BigEndian.Uint32(header[4:])))
+
+		switch stdcopy.StdType(header[0]) {
+		case stdcopy.Stdout:
+			_, err = io.Copy(stdout, frame)
+		case stdcopy.Stderr:
+			_, err = io.Copy(stderr, frame)
+		case stdcopy.Systemerr:
+			var result ExecResult
+			if err := json.NewDecoder(frame).Decode(&result); err != nil {
+				return -1, fmt.Errorf("failed to decode exec result: %w", err)
+			}
+			if result.Error != "" {
+				return result.ExitCode, errors.New(result.Error)
+			}
+			return result.ExitCode, nil
+		default:
+			_, err = io.Copy(io.Discard, frame)
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+}
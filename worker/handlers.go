@@ -2,11 +2,14 @@ package worker
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/store"
 	"github.com/marktlinn/Gorcherstrator/task"
 )
 
@@ -30,6 +33,56 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := a.Worker.Ready(); err != nil {
+		msg := fmt.Sprintf("Task %s not accepted: %v\n", taskEvent.Task.ID, err)
+		log.Println(msg)
+		w.WriteHeader(503)
+		e := ApiErrorResponse{
+			HTTPStatusCode: 503,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+		return
+	}
+
+	if err := a.Worker.CheckMounts(taskEvent.Task); err != nil {
+		msg := fmt.Sprintf("Task %s rejected: %v\n", taskEvent.Task.ID, err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ApiErrorResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+		return
+	}
+
+	current := task.Pending
+	if persisted, err := a.Worker.DB.Get(taskEvent.Task.ID.String()); err == nil {
+		current = persisted.State
+	}
+	if err := task.CheckTransition(current, taskEvent.Task.State); err != nil {
+		msg := fmt.Sprintf("Task %s rejected: %v\n", taskEvent.Task.ID, err)
+		log.Println(msg)
+		// The Manager then takes the Task as the Worker has it, such as Running when asked to schedule it again.
+		if current != task.Pending {
+			a.Worker.acknowledge(taskEvent.Task)
+		}
+		w.WriteHeader(409)
+		e := ApiErrorResponse{
+			HTTPStatusCode: 409,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+		return
+	}
+
 	a.Worker.QueueTask(taskEvent.Task)
 	log.Printf("Task %s added to worker %s task queue", taskEvent.ID, a.Worker.Name)
 	w.WriteHeader(201)
@@ -38,11 +91,38 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetTaskHandler handles requests to retrieve tasks from the Worker. Returns a JSON-encoded list of tasks currently managed by the worker.
+// GetTaskHandler handles requests to retrieve tasks from the Worker. Returns a JSON-encoded list of tasks currently managed by the worker,
+// filtered and paginated by the request's query parameters (see store.ParseTaskQuery).
 func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+
+	q, err := store.ParseTaskQuery(r.URL.Query())
+	if err != nil {
+		msg := fmt.Sprintf("invalid task query: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ApiErrorResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+		return
+	}
+
+	tasks, next, err := a.Worker.QueryTasks(q)
+	if err != nil {
+		log.Printf("failed to query tasks: %s\n", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if next != "" {
+		w.Header().Set(store.NextCursorHeader, next)
+	}
 	w.WriteHeader(200)
-	if err := json.NewEncoder(w).Encode(a.Worker.GetTasks()); err != nil {
+	if err := json.NewEncoder(w).Encode(tasks); err != nil {
 		log.Printf("failed to encode response to json: %s\n", err)
 	}
 }
@@ -50,6 +130,7 @@ func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 // Handles requests to stop a running task. Takes a taskID from the request path,
 // verifies its existence, and adds a copy of the task with a 'Complete' state to
 // the worker's queue. This signals the worker to gracefully stop the original task.
+// The Manager's request carries the Task's Generation in the 'generation' query parameter.
 func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	taskID := r.PathValue("taskID")
 	fmt.Printf("taskID is %s\n", taskID)
@@ -64,10 +145,42 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("No task matches task ID %v\n", taskUUID)
 		w.WriteHeader(404)
+		return
 	}
 
 	fmt.Printf("TargetTask: %+v\n", targetTask)
-	copiedTask := *targetTask.(*task.Task)
+	copiedTask := *targetTask
+	if g := r.URL.Query().Get("generation"); g != "" {
+		generation, err := strconv.ParseUint(g, 10, 64)
+		if err != nil {
+			msg := fmt.Sprintf("invalid generation %q: %v\n", g, err)
+			log.Println(msg)
+			w.WriteHeader(400)
+			e := ApiErrorResponse{
+				HTTPStatusCode: 400,
+				Message:        msg,
+			}
+			if err := json.NewEncoder(w).Encode(e); err != nil {
+				log.Printf("failed to encode response to json: %s\n", err)
+			}
+			return
+		}
+		copiedTask.Generation = generation
+	}
+	if err := task.CheckStop(targetTask.State); err != nil {
+		msg := fmt.Sprintf("Task %v not stopped: %v\n", taskUUID, err)
+		log.Println(msg)
+		a.Worker.acknowledge(copiedTask)
+		w.WriteHeader(409)
+		e := ApiErrorResponse{
+			HTTPStatusCode: 409,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+		return
+	}
 	fmt.Printf("copiedTask: %+v\n", copiedTask)
 	copiedTask.State = task.Complete
 	a.Worker.QueueTask(copiedTask)
@@ -76,6 +189,105 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
 }
 
+// GetTaskLogsHandler streams the output of the Task matching the taskID in the request path, as plain text lines,
+// selected by the request's query parameters (see ParseLogOptions). A request to follow the log is answered
+// until the Task's output stops or the client goes away.
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("taskID")
+	writeError := func(status int, msg string) {
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		e := ApiErrorResponse{
+			HTTPStatusCode: status,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+	}
+
+	opts, err := ParseLogOptions(r.URL.Query())
+	if err != nil {
+		writeError(400, fmt.Sprintf("invalid logs query: %v\n", err))
+		return
+	}
+	if _, err := a.Worker.DB.Get(taskID); err != nil {
+		writeError(404, fmt.Sprintf("No task matches task ID %v\n", taskID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := a.Worker.Logs.Read(r.Context(), taskID, opts, w); err != nil {
+		if errors.Is(err, ErrLogNotFound) {
+			// The Task has not written anything yet.
+			w.WriteHeader(200)
+			return
+		}
+		log.Printf("failed to read logs of task %s: %s\n", taskID, err)
+	}
+}
+
+// ExecTaskHandler handles requests to run a command in the running Task matching the taskID in the request
+// path. The request must be authorized and ask to upgrade its connection, over which the command's input and
+// output are then streamed (see ExecUpgrade).
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	writeError := func(status int, msg string) {
+		log.Println(msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		e := ApiErrorResponse{
+			HTTPStatusCode: status,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+	}
+
+	if status, err := AuthorizeExec(r, a.ExecToken); err != nil {
+		writeError(status, fmt.Sprintf("exec rejected: %v\n", err))
+		return
+	}
+
+	req := ExecRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Cmd) == 0 {
+		writeError(400, fmt.Sprintf("exec requires a command: %v\n", err))
+		return
+	}
+
+	taskID := r.PathValue("taskID")
+	t, err := a.Worker.DB.Get(taskID)
+	if err != nil {
+		writeError(404, fmt.Sprintf("No task matches task ID %v\n", taskID))
+		return
+	}
+	if t.State != task.Running {
+		writeError(409, fmt.Sprintf("Task %v is %s, not running\n", taskID, t.State))
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(500, fmt.Sprintf("failed to hijack connection: %v\n", err))
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\nContent-Type: %s\r\n\r\n",
+		ExecUpgrade, ExecStreamContentType)
+	if err := brw.Flush(); err != nil {
+		log.Printf("failed to upgrade exec connection: %s\n", err)
+		return
+	}
+
+	log.Printf("Running %v in task %s\n", req.Cmd, taskID)
+	res := a.Worker.ExecTask(*t, req, brw.Reader, conn)
+	if err := writeExecResult(conn, res); err != nil {
+		log.Printf("failed to write exec result: %s\n", err)
+	}
+}
+
 // GetStatsHandler provides the api for retrieving the current Stats from a Worker.
 // Worker stats are updated every 15 seconds.
 func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +298,26 @@ func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetReadyHandler reports whether the Worker is ready to run Tasks, responding 200 when it is and 503, along
+// with the reason, when it is not.
+func (a *Api) GetReadyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.Worker.Ready(); err != nil {
+		msg := err.Error()
+		log.Println(msg)
+		w.WriteHeader(503)
+		e := ApiErrorResponse{
+			HTTPStatusCode: 503,
+			Message:        msg,
+		}
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			log.Printf("failed to encode response to json: %s\n", err)
+		}
+		return
+	}
+	w.WriteHeader(200)
+}
+
 func (a *Api) InspectTaskHandler(w http.ResponseWriter, r *http.Request) {
 	taskID := r.PathValue("taskID")
 	if taskID == "" {
@@ -101,11 +333,11 @@ func (a *Api) InspectTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := a.Worker.InspectTask(t.(task.Task))
+	res := a.Worker.InspectTask(*t)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	if err := json.NewEncoder(w).Encode(res.Container); err != nil {
+	if err := json.NewEncoder(w).Encode(res); err != nil {
 		log.Printf("failed to encode response: %s\n", err)
 	}
 }
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// runHook takes the Hook's action against the Task's container, giving up once ctx is done. An exec succeeds
+// when its command exits with code 0, and an HTTP request when it is answered with a 2xx or 3xx status.
+func (w *Worker) runHook(ctx context.Context, t task.Task, h task.Hook) error {
+	if len(h.Exec) > 0 {
+		type execResult struct {
+			code int
+			err  error
+		}
+		// The output of an exec may outlast ctx, so the hook gives up on it without waiting for it to end.
+		done := make(chan execResult, 1)
+		go func() {
+			code, err := w.Runtime.Exec(ctx, t.ContainerID, task.ExecConfig{Cmd: h.Exec}, nil, io.Discard, io.Discard)
+			done <- execResult{code, err}
+		}()
+
+		select {
+		case res := <-done:
+			if res.err != nil {
+				return res.err
+			}
+			if res.code != 0 {
+				return fmt.Errorf("%v exited with code %d", h.Exec, res.code)
+			}
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("%v did not exit in time: %w", h.Exec, ctx.Err())
+		}
+	}
+
+	addr, err := hookAddress(t, h.Port)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s%s", addr, h.HTTPGet), nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return fmt.Errorf("GET %s returned StatusCode %d", h.HTTPGet, res.StatusCode)
+	}
+	return nil
+}
+
+// hookAddress returns the address on the Worker's host at which the Task's container port is published. When
+// port is empty the Task's lowest published port is used.
+func hookAddress(t task.Task, port string) (string, error) {
+	var p nat.Port
+	if port != "" {
+		parsed, err := nat.NewPort(nat.SplitProtoPort(port))
+		if err != nil {
+			return "", fmt.Errorf("invalid port %q", port)
+		}
+		p = parsed
+	} else {
+		ports := make([]nat.Port, 0, len(t.HostPorts))
+		for p := range t.HostPorts {
+			ports = append(ports, p)
+		}
+		sort.Slice(ports, func(i, j int) bool { return ports[i].Int() < ports[j].Int() })
+		if len(ports) == 0 {
+			return "", fmt.Errorf("task %s publishes no ports", t.ID)
+		}
+		p = ports[0]
+	}
+
+	bindings := t.HostPorts[p]
+	if len(bindings) == 0 || bindings[0].HostPort == "" {
+		return "", fmt.Errorf("port %s of task %s is not published", p, t.ID)
+	}
+	return net.JoinHostPort("127.0.0.1", bindings[0].HostPort), nil
+}
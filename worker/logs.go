@@ -0,0 +1,405 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// Default limits on the log files kept for each Task.
+const (
+	DefaultLogMaxSize  = 10 << 20
+	DefaultLogMaxFiles = 3
+)
+
+// ErrLogNotFound is returned when reading the log of a Task which has none.
+var ErrLogNotFound = errors.New("log not found")
+
+// LogEntry is a line a Task's container wrote to its stdout or stderr.
+type LogEntry struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+}
+
+// LogOptions select the entries read from a Task's log.
+type LogOptions struct {
+	// Tail, when positive, limits the entries read to the last Tail.
+	Tail int
+	// Since, when set, skips entries written before it.
+	Since time.Time
+	// Follow keeps reading entries as they are written, for as long as the Task's output is being captured.
+	Follow bool
+}
+
+// ParseLogOptions builds LogOptions from the query parameters of a logs request: tail, a number of entries;
+// since, an RFC 3339 timestamp or a duration, such as "10m", before now; and follow, a boolean.
+func ParseLogOptions(values url.Values) (LogOptions, error) {
+	var opts LogOptions
+	var err error
+	if v := values.Get("tail"); v != "" {
+		if opts.Tail, err = strconv.Atoi(v); err != nil || opts.Tail < 0 {
+			return opts, fmt.Errorf("invalid tail %q", v)
+		}
+	}
+	if v := values.Get("since"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			opts.Since = time.Now().UTC().Add(-d)
+		} else if opts.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, fmt.Errorf("invalid since %q: expected a timestamp or a duration", v)
+		}
+	}
+	if v := values.Get("follow"); v != "" {
+		if opts.Follow, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("invalid follow %q", v)
+		}
+	}
+	return opts, nil
+}
+
+// LogStore keeps the output of a Worker's Tasks in a set of rotating files per Task, in Dir. A Task's entries
+// are written to <taskID>.log, which is rotated to <taskID>.log.1, and so on, once it reaches MaxSize bytes.
+// At most MaxFiles files are kept for a Task, the oldest being removed first.
+type LogStore struct {
+	Dir      string
+	MaxSize  int64
+	MaxFiles int
+
+	mu   sync.Mutex
+	logs map[string]*taskLog
+}
+
+// taskLog is the log of a Task whose output is being captured.
+type taskLog struct {
+	store  *LogStore
+	taskID string
+
+	// mu guards file, size and followers, and orders writes with reads which start following the log.
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	followers map[chan LogEntry]struct{}
+}
+
+// NewLogStore creates a LogStore keeping its files in dir, with the default limits.
+func NewLogStore(dir string) *LogStore {
+	return &LogStore{
+		Dir:      dir,
+		MaxSize:  DefaultLogMaxSize,
+		MaxFiles: DefaultLogMaxFiles,
+		logs:     make(map[string]*taskLog),
+	}
+}
+
+// Capture copies the output of the Task's container into the Task's log until the container exits or ctx is
+// done. It blocks, so is usually run in its own goroutine.
+func (s *LogStore) Capture(ctx context.Context, taskID string, rt task.Runtime, containerID string) {
+	l, err := s.open(taskID)
+	if err != nil {
+		log.Printf("failed to open log of task %s: %s\n", taskID, err)
+		return
+	}
+	defer s.close(l)
+
+	stdout := &lineWriter{log: l, stream: "stdout"}
+	stderr := &lineWriter{log: l, stream: "stderr"}
+	if err := rt.Logs(ctx, containerID, true, stdout, stderr); err != nil && ctx.Err() == nil {
+		log.Printf("failed to capture output of task %s: %s\n", taskID, err)
+	}
+	stdout.flush()
+	stderr.flush()
+}
+
+// Read writes the Task's log entries selected by opts to w, one line each. When following, it returns once the
+// Task's output is no longer captured or ctx is done.
+func (s *LogStore) Read(ctx context.Context, taskID string, opts LogOptions, w io.Writer) error {
+	var l *taskLog
+	if opts.Follow {
+		s.mu.Lock()
+		l = s.logs[taskID]
+		s.mu.Unlock()
+	}
+
+	// The log's files are read while holding its lock, so no entry is written between reading them and
+	// following what comes next.
+	var follow chan LogEntry
+	if l != nil {
+		l.mu.Lock()
+		follow = make(chan LogEntry, 256)
+		l.followers[follow] = struct{}{}
+		defer l.unfollow(follow)
+	}
+	entries, err := s.entries(taskID, opts)
+	if l != nil {
+		l.mu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		if _, err := bw.WriteString(e.Line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := flush(bw, w); err != nil || follow == nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-follow:
+			if !ok {
+				return nil
+			}
+			if !e.Time.Before(opts.Since) {
+				if _, err := bw.WriteString(e.Line + "\n"); err != nil {
+					return err
+				}
+				if err := flush(bw, w); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Remove deletes the Task's log files.
+func (s *LogStore) Remove(taskID string) error {
+	var errs []error
+	for _, path := range s.files(taskID) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// files returns the paths of the Task's log files, oldest first, whether or not they exist.
+func (s *LogStore) files(taskID string) []string {
+	base := filepath.Join(s.Dir, taskID+".log")
+	var paths []string
+	for i := s.MaxFiles - 1; i > 0; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", base, i))
+	}
+	return append(paths, base)
+}
+
+// entries reads the Task's log entries selected by opts, ignoring Follow.
+func (s *LogStore) entries(taskID string, opts LogOptions) ([]LogEntry, error) {
+	var entries []LogEntry
+	found := false
+	for _, path := range s.files(taskID) {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+
+		d := json.NewDecoder(f)
+		for {
+			var e LogEntry
+			if err := d.Decode(&e); err != nil {
+				// A partially written last entry is skipped, as is anything after it.
+				if !errors.Is(err, io.EOF) {
+					log.Printf("failed to decode log entry in %s: %s\n", path, err)
+				}
+				break
+			}
+			if e.Time.Before(opts.Since) {
+				continue
+			}
+			entries = append(entries, e)
+			if opts.Tail > 0 && len(entries) > 2*opts.Tail {
+				entries = append(entries[:0], entries[len(entries)-opts.Tail:]...)
+			}
+		}
+		f.Close()
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: task %s", ErrLogNotFound, taskID)
+	}
+
+	if opts.Tail > 0 && len(entries) > opts.Tail {
+		entries = entries[len(entries)-opts.Tail:]
+	}
+	return entries, nil
+}
+
+// open starts capturing to the Task's log, appending to its existing files.
+func (s *LogStore) open(taskID string) (*taskLog, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, taskID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &taskLog{
+		store:     s,
+		taskID:    taskID,
+		file:      f,
+		size:      info.Size(),
+		followers: make(map[chan LogEntry]struct{}),
+	}
+	s.mu.Lock()
+	s.logs[taskID] = l
+	s.mu.Unlock()
+	return l, nil
+}
+
+// close stops capturing to the Task's log, ending any reads following it.
+func (s *LogStore) close(l *taskLog) {
+	s.mu.Lock()
+	if s.logs[l.taskID] == l {
+		delete(s.logs, l.taskID)
+	}
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c := range l.followers {
+		close(c)
+		delete(l.followers, c)
+	}
+	if err := l.file.Close(); err != nil {
+		log.Printf("failed to close log of task %s: %s\n", l.taskID, err)
+	}
+}
+
+// write appends the entry to the log, rotating its files first if it has grown too large, and hands it to
+// every read following the log. A follower too slow to keep up is dropped.
+func (l *taskLog) write(e LogEntry) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("failed to marshal log entry of task %s: %s\n", l.taskID, err)
+		return
+	}
+	buf = append(buf, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(buf)) > l.store.MaxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("failed to rotate log of task %s: %s\n", l.taskID, err)
+		}
+	}
+	n, err := l.file.Write(buf)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("failed to write log of task %s: %s\n", l.taskID, err)
+	}
+
+	for c := range l.followers {
+		select {
+		case c <- e:
+		default:
+			close(c)
+			delete(l.followers, c)
+		}
+	}
+}
+
+// rotate shifts the log's files along by one, discarding the oldest, and starts a new file. The caller must
+// hold l.mu.
+func (l *taskLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	paths := l.store.files(l.taskID)
+	if len(paths) > 1 {
+		if err := os.Remove(paths[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for i := 1; i < len(paths); i++ {
+		if err := os.Rename(paths[i], paths[i-1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(paths[len(paths)-1], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	l.size = 0
+	return nil
+}
+
+// unfollow stops handing entries to the follower, if it has not already been dropped.
+func (l *taskLog) unfollow(c chan LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.followers[c]; ok {
+		close(c)
+		delete(l.followers, c)
+	}
+}
+
+// lineWriter splits what a container writes to one of its streams into lines, and writes each to a log.
+type lineWriter struct {
+	log    *taskLog
+	stream string
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.write(LogEntry{Time: time.Now().UTC(), Stream: w.stream, Line: string(w.buf[:i])})
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes any final line which was not terminated by a newline.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.log.write(LogEntry{Time: time.Now().UTC(), Stream: w.stream, Line: string(w.buf)})
+		w.buf = nil
+	}
+}
+
+// flush writes out what is buffered in bw and, when w can, flushes it on to w's client.
+func flush(bw *bufio.Writer, w io.Writer) error {
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
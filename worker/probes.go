@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/marktlinn/Gorcherstrator/store"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// A Task restarted by its probes is restarted straight away the first time and then backs off, waiting
+// probeRestartBackoff, doubled for each restart since, up to maxProbeRestartBackoff.
+const (
+	probeRestartBackoff    = 10 * time.Second
+	maxProbeRestartBackoff = 5 * time.Minute
+)
+
+// startProbes starts making the probes of the Task, which has just started running in its container, in
+// place of any the Worker was making of an earlier container of the Task.
+func (w *Worker) startProbes(t task.Task) {
+	if t.StartupProbe == nil && t.LivenessProbe == nil && t.ReadinessProbe == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(w.ctx)
+
+	w.probesMu.Lock()
+	if w.probing == nil {
+		w.probing = make(map[string]context.CancelFunc)
+	}
+	if stop, ok := w.probing[t.ID.String()]; ok {
+		stop()
+	}
+	w.probing[t.ID.String()] = cancel
+	w.probesMu.Unlock()
+
+	go w.probe(ctx, t)
+}
+
+// stopProbes stops making the probes of the Task.
+func (w *Worker) stopProbes(taskID string) {
+	w.probesMu.Lock()
+	defer w.probesMu.Unlock()
+	if stop, ok := w.probing[taskID]; ok {
+		stop()
+		delete(w.probing, taskID)
+	}
+}
+
+// probe makes the Task's probes until ctx is done: its StartupProbe until that passes, then its
+// ReadinessProbe and LivenessProbe side by side.
+func (w *Worker) probe(ctx context.Context, t task.Task) {
+	if t.StartupProbe != nil {
+		started := false
+		w.watch(ctx, t, "startup", *t.StartupProbe, func(passed bool) bool {
+			if passed {
+				started = true
+			} else {
+				w.restartAfterBackoff(ctx, t, "startup probe failed")
+			}
+			return false
+		})
+		if !started {
+			return
+		}
+	}
+
+	if t.ReadinessProbe != nil {
+		go w.watch(ctx, t, "readiness", *t.ReadinessProbe, func(passed bool) bool {
+			w.setReady(t, passed)
+			return true
+		})
+	} else {
+		w.setReady(t, true)
+	}
+
+	if t.LivenessProbe != nil {
+		w.watch(ctx, t, "liveness", *t.LivenessProbe, func(passed bool) bool {
+			if !passed {
+				w.restartAfterBackoff(ctx, t, "liveness probe failed")
+			}
+			return passed
+		})
+	}
+}
+
+// restartAfterBackoff restarts the Task once it has backed off for its RestartCount, unless ctx is done first.
+func (w *Worker) restartAfterBackoff(ctx context.Context, t task.Task, reason string) {
+	if t.RestartCount > 0 {
+		backoff := maxProbeRestartBackoff
+		if t.RestartCount <= 8 {
+			backoff = min(probeRestartBackoff<<(t.RestartCount-1), maxProbeRestartBackoff)
+		}
+		log.Printf("%s for task %s, restarting it in %s\n", reason, t.ID, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+	w.RestartTask(t, reason)
+}
+
+// watch makes the probe of the Task until ctx is done, calling changed each time the probe passes, having
+// not passed before, or fails, having not failed before. watch stops as soon as changed returns false.
+func (w *Worker) watch(ctx context.Context, t task.Task, name string, p task.Probe, changed func(passed bool) bool) {
+	p = p.WithDefaults()
+	wait := time.Duration(p.InitialDelay) * time.Second
+	var successes, failures int
+	var passed *bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = time.Duration(p.Interval) * time.Second
+
+		probeCtx, cancel := context.WithTimeout(ctx, time.Duration(p.Timeout)*time.Second)
+		err := w.check(probeCtx, t, p)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("%s probe of task %s failed: %s\n", name, t.ID, err)
+			successes, failures = 0, failures+1
+		} else {
+			successes, failures = successes+1, 0
+		}
+
+		var now bool
+		switch {
+		case successes >= p.SuccessThreshold:
+			now = true
+		case failures >= p.FailureThreshold:
+			now = false
+		default:
+			continue
+		}
+		if passed != nil && *passed == now {
+			continue
+		}
+		passed = &now
+		if !changed(now) {
+			return
+		}
+	}
+}
+
+// check makes one attempt at the probe of the Task.
+func (w *Worker) check(ctx context.Context, t task.Task, p task.Probe) error {
+	if p.TCPSocket == "" {
+		return w.runHook(ctx, t, p.Hook)
+	}
+	addr, err := hookAddress(t, p.TCPSocket)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// setReady records whether the Task is Ready, provided it is still running in the same container.
+func (w *Worker) setReady(t task.Task, ready bool) {
+	_, err := store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		if persisted.State != task.Running || persisted.ContainerID != t.ContainerID {
+			return persisted, nil
+		}
+		persisted.Ready = ready
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to update task %s in store: %s\n", t.ID, err)
+		return
+	}
+	log.Printf("Task %s ready: %t\n", t.ID, ready)
+}
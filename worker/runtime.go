@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"context"
+	"io"
+
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// unavailableRuntime stands in for a Runtime which could not be created, failing every call with the reason.
+type unavailableRuntime struct {
+	err error
+}
+
+func (u unavailableRuntime) Run(ctx context.Context, c *task.Config) task.Result {
+	return task.Result{Error: u.err}
+}
+
+func (u unavailableRuntime) Stop(ctx context.Context, containerID string, opts task.StopOptions) task.Result {
+	return task.Result{Error: u.err}
+}
+
+func (u unavailableRuntime) Inspect(ctx context.Context, containerID string) task.InspectResult {
+	return task.InspectResult{ContainerID: containerID, Error: u.err}
+}
+
+func (u unavailableRuntime) List(ctx context.Context, labels map[string]string) ([]task.InspectResult, error) {
+	return nil, u.err
+}
+
+func (u unavailableRuntime) Logs(ctx context.Context, containerID string, follow bool, stdout, stderr io.Writer) error {
+	return u.err
+}
+
+func (u unavailableRuntime) Stats(ctx context.Context, containerID string) (*task.ContainerStats, error) {
+	return nil, u.err
+}
+
+func (u unavailableRuntime) Exec(ctx context.Context, containerID string, cfg task.ExecConfig, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return -1, u.err
+}
+
+func (u unavailableRuntime) Ping(ctx context.Context) error {
+	return u.err
+}
+
+func (u unavailableRuntime) Close() error {
+	return nil
+}
@@ -1,9 +1,11 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/golang-collections/collections/queue"
@@ -12,9 +14,19 @@ import (
 	"github.com/marktlinn/Gorcherstrator/task"
 )
 
+// Deadlines for the calls a Worker makes to its Runtime.
+const (
+	// runTimeout includes pulling the Task's image.
+	runTimeout     = 10 * time.Minute
+	inspectTimeout = 30 * time.Second
+	pingTimeout    = 5 * time.Second
+	// stopTimeout is allowed on top of the Task's grace period.
+	stopTimeout = 30 * time.Second
+)
+
 // A Worker has is the layer above a Task.
 // It is responsible for:
-// - Runing Tasks in Docker containers.
+// - Runing Tasks in containers through its Runtime.
 // - Providing stats on runnings Tasks to the manager.
 // - Tracking the State of Tasks
 // - Accepting instruction from the Manager to run Tasks.
@@ -23,70 +35,219 @@ type Worker struct {
 	Queue queue.Queue
 	Name  string
 	// DB represents the current actual state of the Tasks.
-	DB        store.Store
+	DB        store.Store[*task.Task]
 	Stats     *stats.Stats
 	TaskCount int
+	// TaskRetention bounds how long, and how many, Complete and Failed Tasks are kept in the DB.
+	TaskRetention store.RetentionPolicy
+	// Runtime runs the Worker's Tasks in containers.
+	Runtime task.Runtime
+	// Logs keeps the output of the Worker's Tasks.
+	Logs *LogStore
+	// RegistryAuth maps registry hosts, such as "docker.io", to the credentials used to pull images from them.
+	RegistryAuth map[string]task.RegistryAuth
+	// AllowedHostPaths lists the host directories Tasks may bind mount, along with anything beneath them.
+	// Tasks may not bind mount any host path when it is empty.
+	AllowedHostPaths []string
+	// OrphanPolicy decides what AdoptContainers does with the containers it finds which belong to no Task.
+	OrphanPolicy string
+
+	// ctx is done once the Worker is closed, cancelling its loops and any calls to its Runtime.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// probing holds, for each Task whose probes are being made, the function to stop making them.
+	probesMu sync.Mutex
+	probing  map[string]context.CancelFunc
 }
 
-// New creates a new instance of a TaskSTore of the specified DbType
-func New(name, dbType string) *Worker {
+// New creates a new Worker with a TaskStore of the specified dbType, running its Tasks with a Runtime
+// of the specified runtimeType. A Worker whose Runtime cannot be created is still returned, but is never
+// Ready, and reports why.
+func New(name, dbType, runtimeType string) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
 	w := Worker{
-		Name:  name,
-		Queue: *queue.New(),
+		ctx:    ctx,
+		cancel: cancel,
+		Name:   name,
+		Queue:  *queue.New(),
+		Logs:   NewLogStore(fmt.Sprintf("%s_logs", name)),
+		TaskRetention: store.RetentionPolicy{
+			MaxAge:   time.Hour,
+			MaxCount: 100,
+		},
 	}
 
-	var s store.Store
+	var s store.Store[*task.Task]
 	switch dbType {
 	case store.MEMORY:
 		s = store.NewInMemoryTaskStore()
+	case store.PERSISTENT:
+		ts, err := store.NewTaskStore(fmt.Sprintf("%s_tasks.db", name), 0600, "tasks")
+		if err != nil {
+			log.Printf("failed to create persistent task store: %s\n", err)
+			return nil
+		}
+		s = ts
 	default:
 		log.Printf("failed to create taskDBStore of type '%s'\n", dbType)
 		return nil
 	}
 	w.DB = s
+	w.OrphanPolicy = OrphanKeep
+	if dbType == store.PERSISTENT {
+		w.OrphanPolicy = OrphanRemove
+	}
+
+	rt, err := task.NewRuntime(runtimeType)
+	if err != nil {
+		log.Printf("failed to create runtime: %s\n", err)
+		rt = unavailableRuntime{err: err}
+	}
+	w.Runtime = rt
 	return &w
 }
 
+// Ready reports why the Worker cannot run Tasks, if it cannot: it has been closed, or its Runtime is
+// unavailable.
+func (w *Worker) Ready() error {
+	if w.ctx.Err() != nil {
+		return fmt.Errorf("worker %s is closed", w.Name)
+	}
+	ctx, cancel := context.WithTimeout(w.ctx, pingTimeout)
+	defer cancel()
+	if err := w.Runtime.Ping(ctx); err != nil {
+		return fmt.Errorf("worker %s runtime is not ready: %w", w.Name, err)
+	}
+	return nil
+}
+
+// Close stops the Worker's loops, cancels its outstanding calls to its Runtime and releases the Runtime.
+// The Worker's containers are left running.
+func (w *Worker) Close() error {
+	w.cancel()
+	return w.Runtime.Close()
+}
+
+// wait pauses one of the Worker's loops for d, reporting false if the Worker was closed meanwhile.
+func (w *Worker) wait(d time.Duration) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
 // Starts a task, setting the start time of the task
-// and creating a Docker instance to communicate with the Docker
-// Daemon.
-func (w *Worker) StartTask(t task.Task) task.DockerResult {
+// and running it in a container through the Worker's Runtime.
+// Once running, the Task is Ready straight away unless it has a StartupProbe or ReadinessProbe to pass first.
+// A Task stopped while it starts, as a Restarting Task may be, has its new container removed again.
+func (w *Worker) StartTask(t task.Task) task.Result {
 	t.StartTime = time.Now().UTC()
+	t.Ready = false
 	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
+	config.Labels[task.LabelWorker] = w.Name
+	if registry, err := task.Registry(t.Image); err == nil {
+		if auth, ok := w.RegistryAuth[registry]; ok {
+			config.RegistryAuth = &auth
+		}
+	}
 
-	result := d.Run()
+	var result task.Result
+	if err := w.CheckMounts(t); err != nil {
+		result.Error = err
+	} else {
+		ctx, cancel := context.WithTimeout(w.ctx, runTimeout)
+		result = w.Runtime.Run(ctx, config)
+		cancel()
+	}
+	t.PullEvents = result.PullEvents
 	if result.Error != nil {
 		log.Printf("Error running task %v: %v\n", t.ID, result.Error)
-		if err := w.DB.Put(t.ID.String(), &t); err != nil {
+		if err := t.Transition(task.Failed, fmt.Sprintf("failed to start: %s", result.Error)); err != nil {
+			log.Printf("task %s: %s\n", t.ID, err)
+		}
+		if err := w.storeStarted(t); err != nil {
 			log.Printf("failed to insert task %s into store: %s\n", t.ID.String(), err)
 		}
-		t.State = task.Failed
 		return result
 	}
 
-	t.State = task.Running
+	// What the Runtime could not apply of the Task, such as its Disk limit, is recorded with why it is Running.
+	reason := fmt.Sprintf("started in container %s on worker %s", result.ContainerID, w.Name)
+	for _, warning := range result.Warnings {
+		reason += "; " + warning
+	}
+	if err := t.Transition(task.Running, reason); err != nil {
+		log.Printf("task %s: %s\n", t.ID, err)
+	}
 	t.ContainerID = result.ContainerID
-	if err := w.DB.Put(t.ID.String(), &t); err != nil {
+	// The Task's probes need to know where its ports are published.
+	if res := w.InspectTask(t); res.Error == nil {
+		t.HostPorts = res.HostPorts
+	}
+	t.Ready = t.StartupProbe == nil && t.ReadinessProbe == nil
+	if err := w.storeStarted(t); err != nil {
 		log.Printf("failed to insert task %s into store: %s\n", t.ID.String(), err)
+		w.removeContainer(t.ID.String(), result.ContainerID)
+		result.Error = err
+		return result
 	}
+	go w.Logs.Capture(w.ctx, t.ID.String(), w.Runtime, result.ContainerID)
+	w.startProbes(t)
 	return result
 }
 
+// storeStarted stores the Task StartTask has started, or failed to start, unless the Task it replaces in the
+// Worker's DB has since moved on from being Scheduled or Restarting, such as to Stopping.
+func (w *Worker) storeStarted(t task.Task) error {
+	if _, err := w.DB.Get(t.ID.String()); err != nil {
+		return w.DB.Put(t.ID.String(), &t)
+	}
+	_, err := store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		if persisted.State != task.Scheduled && persisted.State != task.Restarting {
+			return nil, fmt.Errorf("task %s became %s while starting", t.ID, persisted.State)
+		}
+		t.Generation = max(t.Generation, persisted.Generation)
+		return &t, nil
+	})
+	return err
+}
+
+// acknowledge records that the Worker has dealt with the Manager's request for the Task, of the Task's
+// Generation, even though it did not act on it, so the Manager takes the Worker's reports of the Task as
+// current again.
+func (w *Worker) acknowledge(t task.Task) {
+	_, err := store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		persisted.Generation = max(persisted.Generation, t.Generation)
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to acknowledge request for task %s: %s\n", t.ID, err)
+	}
+}
+
 // RunTasks pops a queued task from the Worker's queue and runs it.
 // RunTasks intermittently (every 10 seconds) checks for tasks on the Worker's queue.
+// Queued tasks are left on the queue while the Worker is not Ready. RunTasks returns once the Worker is closed.
 func (w *Worker) RunTasks() {
 	for {
 		if w.Queue.Len() != 0 {
-			result := w.runTask()
-			if result.Error != nil {
-				log.Printf("Error running task: %v\n", result.Error)
+			if err := w.Ready(); err != nil {
+				log.Printf("Not processing %d queued tasks: %s\n", w.Queue.Len(), err)
+			} else {
+				result := w.runTask()
+				if result.Error != nil {
+					log.Printf("Error running task: %v\n", result.Error)
+				}
 			}
 		} else {
 			log.Printf("No tasks to process currently.\n")
 		}
 		log.Println("Sleeping for 10 seconds.")
-		time.Sleep(10 * time.Second)
+		if !w.wait(10 * time.Second) {
+			return
+		}
 	}
 }
 
@@ -97,11 +258,13 @@ func (w *Worker) UpdateTasks() {
 		log.Printf("Updating task status on Worker %s\n", w.Name)
 		w.updateTasks()
 		log.Printf("Tasks updated for Worker %s; sleeping for %d\n", w.Name, rest)
-		time.Sleep(rest * time.Second)
+		if !w.wait(rest * time.Second) {
+			return
+		}
 	}
 }
 
-// updateTasks is a helper method. The updateTasks method, simply queries thetask's state from the Docker daemon. to determine if the task's state is `running` or not.
+// updateTasks is a helper method. The updateTasks method, simply queries thetask's state from the Worker's Runtime. to determine if the task's state is `running` or not.
 // If not the task's state is not `running`, it sets it to `failed`.
 func (w *Worker) updateTasks() {
 	tasks, err := w.DB.List()
@@ -110,53 +273,100 @@ func (w *Worker) updateTasks() {
 		return
 	}
 
-	for _, t := range tasks.([]*task.Task) {
+	for _, t := range tasks {
 		if t.State == task.Running {
 			res := w.InspectTask(*t)
-			if res.Error != nil {
+			if res.Error != nil && !errors.Is(res.Error, task.ErrContainerNotFound) {
 				log.Printf(
-					"failed to inspect task %s in docker container: %s\n", t.ID.String(),
+					"failed to inspect task %s in container: %s\n", t.ID.String(),
 					res.Error,
 				)
+				continue
 			}
 
-			if res.Container == nil {
-				log.Printf(
-					"failed to locate Docker container for task %s in response: %+v\n",
-					t.ID.String(),
-					res,
-				)
-				if err := w.DB.Put(t.ID.String(), t); err != nil {
-					log.Printf("failed to insert task %s into store: %s\n", t.ID.String(), err)
+			_, err := store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+				// The task may have been stopped while its container was being inspected.
+				if persisted.State != task.Running {
+					return persisted, nil
 				}
-				t.State = task.Failed
-			}
 
-			if res.Container.State.Status == "exited" {
-				log.Printf(
-					"Container for task %s, not running, found in %s state\n", t.ID.String(),
-					res.Container.State.Status,
-				)
-				if err := w.DB.Put(t.ID.String(), t); err != nil {
-					log.Printf("failed to insert task %s into store: %s\n", t.ID.String(), err)
+				switch {
+				case res.Error != nil:
+					log.Printf(
+						"failed to locate container for task %s: %s\n",
+						t.ID.String(),
+						res.Error,
+					)
+					if err := persisted.Transition(task.Lost, fmt.Sprintf("container %s has gone", persisted.ContainerID)); err != nil {
+						return nil, err
+					}
+					persisted.Ready = false
+					w.stopProbes(t.ID.String())
+				case res.Status == task.StatusExited:
+					persisted.ExitCode = res.ExitCode
+					persisted.FinishTime = res.FinishedAt
+					if persisted.FinishTime.IsZero() {
+						persisted.FinishTime = time.Now().UTC()
+					}
+					reason := fmt.Sprintf("container exited with code %d", res.ExitCode)
+					if err := persisted.Transition(persisted.ExitState(res.ExitCode), reason); err != nil {
+						return nil, err
+					}
+					persisted.Ready = false
+					w.stopProbes(t.ID.String())
+					log.Printf(
+						"Container for task %s exited with code %d; task is %s\n", t.ID.String(),
+						res.ExitCode,
+						persisted.State,
+					)
+				default:
+					persisted.HostPorts = res.HostPorts
+					persisted.DiskUsed = res.DiskUsage
 				}
-				t.State = task.Failed
-			}
-
-			t.HostPorts = res.Container.NetworkSettings.NetworkSettingsBase.Ports
-			if err := w.DB.Put(t.ID.String(), t); err != nil {
-				log.Printf("failed to insert task %s into store: %s\n", t.ID.String(), err)
+				return persisted, nil
+			})
+			if err != nil {
+				log.Printf("failed to update task %s in store: %s\n", t.ID.String(), err)
 			}
 		}
 	}
 }
 
-// StopTask stops a Task which is running on the Worker, gracefully.
-func (w *Worker) StopTask(t task.Task) task.DockerResult {
-	config := task.NewConfig(&t)
-	docker := task.NewDocker(config)
+// StopTask stops a Task which is running on the Worker, gracefully. The Task is Stopping while its PreStop
+// hook runs and its container is given the rest of its grace period to exit, after which it is Complete.
+// A Task which cannot be stopped from its current State is left alone.
+func (w *Worker) StopTask(t task.Task) task.Result {
+	opts := t.StopOptions()
+	stopping, err := store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		reason := fmt.Sprintf("stopping with %s and a grace period of %s", opts.Signal, opts.Timeout)
+		if err := persisted.Transition(task.Stopping, reason); err != nil {
+			return nil, err
+		}
+		persisted.Ready = false
+		persisted.Generation = max(persisted.Generation, t.Generation)
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to stop task %s: %s\n", t.ID, err)
+		w.acknowledge(t)
+		return task.Result{Action: "stop", Error: err}
+	}
+	w.stopProbes(t.ID.String())
+	t = *stopping
+
+	if t.PreStop != nil {
+		started := time.Now()
+		ctx, cancel := context.WithTimeout(w.ctx, opts.Timeout)
+		if err := w.runHook(ctx, t, *t.PreStop); err != nil {
+			log.Printf("pre-stop hook of task %s failed: %s\n", t.ID, err)
+		}
+		cancel()
+		opts.Timeout = max(opts.Timeout-time.Since(started), 0)
+	}
 
-	result := docker.Stop(t.ContainerID)
+	ctx, cancel := context.WithTimeout(w.ctx, opts.Timeout+stopTimeout)
+	defer cancel()
+	result := w.Runtime.Stop(ctx, t.ContainerID, opts)
 	if result.Error != nil {
 		log.Printf("Error stopping container %v: %v\n",
 			t.ContainerID,
@@ -164,20 +374,86 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 		)
 	}
 
-	t.FinishTime = time.Now().UTC()
-	t.State = task.Complete
-	if err := w.DB.Put(t.ID.String(), &t); err != nil {
-		log.Printf("failed to insert task %s into store: %s\n", t.ID.String(), err)
+	_, err = store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		// The container may have exited on its own, and been seen to, during the grace period.
+		if task.IsTerminal(persisted.State) {
+			return persisted, nil
+		}
+		persisted.FinishTime = time.Now().UTC()
+		if err := persisted.Transition(task.Complete, "stopped"); err != nil {
+			return nil, err
+		}
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to update task %s in store: %s\n", t.ID.String(), err)
 	}
 	log.Printf("Container %v stopped and removed for task %v", t.ContainerID, t.ID)
 	return result
 }
 
-// InspectTask sets up a task config and Docker instance in order to allow for interaction with the running Docker daemon which is running on the given Worker.
-func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
-	return d.Inspect(t.ContainerID)
+// RestartTask replaces the container of a Task on the Worker with a new one, for the given reason. The Task
+// is Restarting while its old container is stopped, as though the Task were being stopped, and the new one
+// started. A Task no longer in the container t names, or which cannot be restarted from its current State,
+// is left alone.
+func (w *Worker) RestartTask(t task.Task, reason string) task.Result {
+	restarting, err := store.Update(w.DB, t.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		if persisted.ContainerID != t.ContainerID {
+			return nil, fmt.Errorf("task %s is no longer in container %s", t.ID, t.ContainerID)
+		}
+		if err := persisted.Transition(task.Restarting, reason); err != nil {
+			return nil, err
+		}
+		persisted.Ready = false
+		persisted.Generation = max(persisted.Generation, t.Generation)
+		return persisted, nil
+	})
+	if err != nil {
+		log.Printf("failed to restart task %s: %s\n", t.ID, err)
+		// The Manager then sees the Task as the Worker left it, rather than waiting for it to restart.
+		w.acknowledge(t)
+		return task.Result{Action: "restart", Error: err}
+	}
+	w.stopProbes(t.ID.String())
+	log.Printf("Restarting task %s: %s\n", t.ID, reason)
+
+	opts := restarting.StopOptions()
+	ctx, cancel := context.WithTimeout(w.ctx, opts.Timeout+stopTimeout)
+	defer cancel()
+	if result := w.Runtime.Stop(ctx, restarting.ContainerID, opts); result.Error != nil {
+		log.Printf("Error stopping container %v: %v\n", restarting.ContainerID, result.Error)
+	}
+
+	restarting.RestartCount++
+	result := w.StartTask(*restarting)
+	result.Action = "restart"
+	if result.Error != nil {
+		log.Printf("failed to restart task %s: %s\n", t.ID, result.Error)
+		return result
+	}
+	log.Printf("Task %s restarted in container %s\n", t.ID, result.ContainerID)
+	return result
+}
+
+// InspectTask asks the Worker's Runtime for the current state of the Task's container.
+func (w *Worker) InspectTask(t task.Task) task.InspectResult {
+	ctx, cancel := context.WithTimeout(w.ctx, inspectTimeout)
+	defer cancel()
+	return w.Runtime.Inspect(ctx, t.ContainerID)
+}
+
+// CheckMounts checks the Task's Mounts are well formed and only bind mount host paths within the Worker's
+// AllowedHostPaths.
+func (w *Worker) CheckMounts(t task.Task) error {
+	for _, m := range t.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		if m.Type == task.MountBind && !task.AllowedHostPath(m.Source, w.AllowedHostPaths) {
+			return fmt.Errorf("bind mount of %s is outside the host paths allowed on worker %s", m.Source, w.Name)
+		}
+	}
+	return nil
 }
 
 // Enqueues a task to the Worker queue
@@ -192,7 +468,20 @@ func (w *Worker) GetTasks() []*task.Task {
 		log.Printf("failed to create list of tasks %s\n", err)
 		return nil
 	}
-	return tasks.([]*task.Task)
+	return tasks
+}
+
+// QueryTasks returns the page of Tasks within the Worker's DB matching the given query,
+// along with the cursor for the next page.
+func (w *Worker) QueryTasks(q store.TaskQuery) ([]*task.Task, string, error) {
+	tasks, next, err := store.QueryTasks(w.DB, q)
+	if err != nil {
+		return nil, "", err
+	}
+	if tasks == nil {
+		tasks = []*task.Task{}
+	}
+	return tasks, next, nil
 }
 
 // CollectStats runs GetStats() to maintain an up-to-date collection of stats from a Worker about the Worker and its Tasks.
@@ -203,53 +492,114 @@ func (w *Worker) CollectStats() {
 		w.Stats = stats.GetStats()
 		w.Stats.TaskCount = w.TaskCount
 		log.Printf("taskCount was: %d\n", w.Stats.TaskCount)
-		time.Sleep(15 * time.Second)
+		if !w.wait(15 * time.Second) {
+			return
+		}
 	}
 }
 
 // RunTask method runs a task on the worker.
 // RunTask assess the State of the Task.
 // If a Task is not started, RunTask starts the Task.
-// Else if a Task's state is started, RunTask will stop the task.
-func (w *Worker) runTask() task.DockerResult {
+// Else if a Task's state is started, RunTask will stop or restart the task, as asked.
+// Queued Tasks whose State is not a valid transition from their current one are rejected.
+func (w *Worker) runTask() task.Result {
 	t := w.Queue.Dequeue()
 	if t == nil {
 		log.Println("Queue is empty, no tasks to process")
-		return task.DockerResult{Error: nil}
+		return task.Result{Error: nil}
 	}
 
-	taskToPutInDb := t.(task.Task)
+	taskQueued := t.(task.Task)
 
-	if err := w.DB.Put(taskToPutInDb.ID.String(), &taskToPutInDb); err != nil {
-		errMsg := fmt.Errorf("failed to store task %s in DB: %s\n", taskToPutInDb.ID, err)
-		log.Println(errMsg)
-		return task.DockerResult{Error: errMsg}
+	// A task the Worker has not seen before is treated as Pending, so only a
+	// request to schedule it is a valid transition.
+	currentState := task.Pending
+	if taskPersisted, err := w.DB.Get(taskQueued.ID.String()); err == nil {
+		currentState = taskPersisted.State
 	}
-
-	taskGotFromDB, err := w.DB.Get(taskToPutInDb.ID.String())
-	if err != nil {
-		errMsg := fmt.Errorf("failed to GET task %s from DB: %s\n", taskToPutInDb.ID, err)
-		return task.DockerResult{Error: errMsg}
+	err := task.CheckTransition(currentState, taskQueued.State)
+	if taskQueued.State == task.Complete {
+		// A Task is stopped by way of Stopping, which a Restarting Task can move to, but not straight to Complete.
+		err = task.CheckStop(currentState)
 	}
 
-	taskPersisted := *taskGotFromDB.(*task.Task)
-
-	var result task.DockerResult
-	if task.ValidStateTransition(taskPersisted.State, taskToPutInDb.State) {
-		switch taskToPutInDb.State {
+	var result task.Result
+	if err == nil {
+		switch taskQueued.State {
 		case task.Scheduled:
-			result = w.StartTask(taskToPutInDb)
+			result = w.StartTask(taskQueued)
+		case task.Restarting:
+			// Like stopping, restarting a Task gives its old container its grace period.
+			go w.RestartTask(taskQueued, taskQueued.StateReason)
+			result = task.Result{Action: "restart", Result: "restarting"}
 		case task.Complete:
-			result = w.StopTask(taskToPutInDb)
+			if currentState == task.Stopping {
+				log.Printf("task %s is already stopping\n", taskQueued.ID)
+				w.acknowledge(taskQueued)
+				break
+			}
+			// A Task can take its whole grace period to stop, which must not hold up the rest of the queue.
+			go w.StopTask(taskQueued)
+			result = task.Result{Action: "stop", Result: "stopping"}
 		default:
-			unexpectedError := fmt.Errorf("undefined state of queued task: %+v\n", taskToPutInDb.State).
+			unexpectedError := fmt.Errorf("undefined state of queued task: %+v\n", taskQueued.State).
 				Error()
 			result.Error = errors.New(unexpectedError)
 		}
 	} else {
-		err := fmt.Errorf("error: transition from %+v to %+v is not valid", taskPersisted.State, taskToPutInDb.State)
-		result.Error = err
+		result.Error = fmt.Errorf("task %s: %w", taskQueued.ID, err)
+		if currentState != task.Pending {
+			w.acknowledge(taskQueued)
+		}
 	}
 
 	return result
 }
+
+// CollectGarbage periodically prunes the finished Tasks that fall outside the Worker's TaskRetention policy.
+func (w *Worker) CollectGarbage() {
+	var rest time.Duration = 300
+	for {
+		report := w.collectGarbage()
+		log.Printf("Garbage collection complete on Worker %s: %s; next run in %d seconds\n", w.Name, report, rest)
+		if !w.wait(rest * time.Second) {
+			return
+		}
+	}
+}
+
+// collectGarbage runs a single pruning pass over the Worker's DB and reports what it removed.
+// The logs of pruned Tasks are removed too, along with the containers of those which exited on their own.
+func (w *Worker) collectGarbage() store.PruneReport {
+	containers := make(map[string]string)
+	for _, t := range w.GetTasks() {
+		containers[t.ID.String()] = t.ContainerID
+	}
+
+	tasks, err := store.PruneTasks(w.DB, w.TaskRetention, time.Now().UTC())
+	if err != nil {
+		log.Printf("failed to prune tasks: %s\n", err)
+	}
+	for _, id := range tasks {
+		if err := w.Logs.Remove(id); err != nil {
+			log.Printf("failed to remove logs of task %s: %s\n", id, err)
+		}
+		w.removeContainer(id, containers[id])
+	}
+	return store.PruneReport{Tasks: tasks}
+}
+
+// removeContainer removes a container of the Task, if it is still there. The container of a Task which exited
+// on its own is kept until the Task is pruned, so it can still be inspected.
+func (w *Worker) removeContainer(taskID, containerID string) {
+	if containerID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(w.ctx, stopTimeout)
+	defer cancel()
+	res := w.Runtime.Stop(ctx, containerID, task.StopOptions{})
+	if res.Error != nil && !errors.Is(res.Error, task.ErrContainerNotFound) {
+		log.Printf("failed to remove container %s of task %s: %s\n", containerID, taskID, res.Error)
+	}
+}
@@ -0,0 +1,326 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marktlinn/Gorcherstrator/store"
+	"github.com/marktlinn/Gorcherstrator/task"
+)
+
+// newTestWorker creates a Worker running its Tasks on a FakeRuntime.
+func newTestWorker(t *testing.T) (*Worker, *task.FakeRuntime) {
+	t.Helper()
+	w := New("test-worker", store.MEMORY, task.FAKE)
+	if w == nil {
+		t.Fatal("failed to create worker")
+	}
+	// Logs are captured in the background, and may still be written to as the test ends.
+	dir, err := os.MkdirTemp("", "worker-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Logs = NewLogStore(dir)
+	t.Cleanup(func() {
+		w.Close()
+		os.RemoveAll(dir)
+	})
+	return w, w.Runtime.(*task.FakeRuntime)
+}
+
+// schedule queues the Task to be started and runs it, returning the Task as stored once it is running.
+func schedule(t *testing.T, w *Worker, tk task.Task) *task.Task {
+	t.Helper()
+	tk.State = task.Scheduled
+	w.QueueTask(tk)
+	if res := w.runTask(); res.Error != nil {
+		t.Fatalf("failed to start task: %v", res.Error)
+	}
+	return get(t, w, tk.ID)
+}
+
+func get(t *testing.T, w *Worker, id uuid.UUID) *task.Task {
+	t.Helper()
+	tk, err := w.DB.Get(id.String())
+	if err != nil {
+		t.Fatalf("failed to get task %s: %v", id, err)
+	}
+	return tk
+}
+
+// waitForState waits for the stored Task to reach the State.
+func waitForState(t *testing.T, w *Worker, id uuid.UUID, state task.State) *task.Task {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tk := get(t, w, id)
+		if tk.State == state {
+			return tk
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s is %s, want %s", id, tk.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	w, _ := newTestWorker(t)
+	id := uuid.New()
+
+	running := schedule(t, w, task.Task{ID: id, Name: "web", Image: "nginx"})
+	if running.State != task.Running || running.ContainerID == "" || !running.Ready {
+		t.Fatalf("started task is %s in container %q with Ready %v, want a ready Running task in a container",
+			running.State, running.ContainerID, running.Ready)
+	}
+
+	stop := *running
+	stop.State = task.Complete
+	w.QueueTask(stop)
+	if res := w.runTask(); res.Error != nil {
+		t.Fatalf("failed to stop task: %v", res.Error)
+	}
+	stopped := waitForState(t, w, id, task.Complete)
+	if stopped.FinishTime.IsZero() {
+		t.Error("stopped task has no FinishTime")
+	}
+	if res := w.InspectTask(*stopped); !errors.Is(res.Error, task.ErrContainerNotFound) {
+		t.Errorf("container of stopped task still exists: %+v", res)
+	}
+
+	// A stopped Task cannot be stopped again.
+	w.QueueTask(stop)
+	if res := w.runTask(); !errors.Is(res.Error, task.ErrInvalidTransition) {
+		t.Errorf("stopping a Complete task returned %v, want %v", res.Error, task.ErrInvalidTransition)
+	}
+}
+
+func TestTaskExits(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		exitCode int
+		want     task.State
+	}{
+		{name: "batch task succeeds", kind: task.KindBatch, exitCode: 0, want: task.Complete},
+		{name: "batch task fails", kind: task.KindBatch, exitCode: 1, want: task.Failed},
+		{name: "service task exits", kind: task.KindService, exitCode: 0, want: task.Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker(t)
+			running := schedule(t, w, task.Task{ID: uuid.New(), Name: "job", Image: "busybox", Kind: tt.kind})
+
+			if err := rt.Exit(running.ContainerID, tt.exitCode); err != nil {
+				t.Fatal(err)
+			}
+			w.updateTasks()
+
+			exited := get(t, w, running.ID)
+			if exited.State != tt.want || exited.ExitCode != tt.exitCode || exited.Ready {
+				t.Errorf("exited task is %s with exit code %d and Ready %v, want %s with exit code %d",
+					exited.State, exited.ExitCode, exited.Ready, tt.want, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestTaskLost(t *testing.T) {
+	w, rt := newTestWorker(t)
+	running := schedule(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx"})
+
+	// The container disappearing behind the Worker's back loses the Task.
+	rt.Stop(context.Background(), running.ContainerID, task.StopOptions{})
+	w.updateTasks()
+
+	if lost := get(t, w, running.ID); lost.State != task.Lost {
+		t.Errorf("task whose container has gone is %s, want %s", lost.State, task.Lost)
+	}
+}
+
+func TestTaskFailsToStart(t *testing.T) {
+	w, rt := newTestWorker(t)
+	rt.RunError = errors.New("no space left on device")
+
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+	w.QueueTask(tk)
+	if res := w.runTask(); res.Error == nil {
+		t.Fatal("task started although its container could not be run")
+	}
+
+	failed := get(t, w, tk.ID)
+	if failed.State != task.Failed || failed.ContainerID != "" {
+		t.Errorf("task which could not start is %s in container %q, want %s", failed.State, failed.ContainerID, task.Failed)
+	}
+}
+
+func TestTaskRestarts(t *testing.T) {
+	w, _ := newTestWorker(t)
+	running := schedule(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx"})
+
+	res := w.RestartTask(*running, "restart requested")
+	if res.Error != nil {
+		t.Fatalf("failed to restart task: %v", res.Error)
+	}
+
+	restarted := get(t, w, running.ID)
+	if restarted.State != task.Running || restarted.RestartCount != 1 {
+		t.Errorf("restarted task is %s with RestartCount %d, want %s with RestartCount 1",
+			restarted.State, restarted.RestartCount, task.Running)
+	}
+	if restarted.ContainerID == running.ContainerID {
+		t.Error("restarted task kept its old container")
+	}
+}
+
+func TestCollectGarbageRemovesContainers(t *testing.T) {
+	w, rt := newTestWorker(t)
+	w.TaskRetention = store.RetentionPolicy{MaxCount: 1}
+
+	var exited []*task.Task
+	for range 3 {
+		running := schedule(t, w, task.Task{ID: uuid.New(), Name: "job", Image: "busybox", Kind: task.KindBatch})
+		if err := rt.Exit(running.ContainerID, 0); err != nil {
+			t.Fatal(err)
+		}
+		exited = append(exited, running)
+		time.Sleep(time.Millisecond)
+	}
+	w.updateTasks()
+
+	report := w.collectGarbage()
+	if len(report.Tasks) != 2 {
+		t.Fatalf("pruned %d tasks, want 2", len(report.Tasks))
+	}
+	for _, tk := range exited {
+		res := w.InspectTask(*tk)
+		pruned := slices.Contains(report.Tasks, tk.ID.String())
+		if gone := errors.Is(res.Error, task.ErrContainerNotFound); gone != pruned {
+			t.Errorf("task %s pruned %v but its container removed %v", tk.ID, pruned, gone)
+		}
+	}
+}
+
+func TestAdoptContainersRebuildsTasks(t *testing.T) {
+	w, rt := newTestWorker(t)
+	running := schedule(t, w, task.Task{
+		ID:    uuid.New(),
+		Name:  "web",
+		Image: "nginx",
+		Env:   map[string]string{"PORT": "8080"},
+	})
+
+	// A Worker with a memory store restarts knowing none of its Tasks.
+	restarted, _ := newTestWorker(t)
+	restarted.Runtime = rt
+	report, err := restarted.AdoptContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Adopted) != 1 || len(report.Orphaned) != 0 {
+		t.Fatalf("adopt report is %s, want 1 adopted and none orphaned", report)
+	}
+
+	adopted := get(t, restarted, running.ID)
+	if adopted.State != task.Running || adopted.ContainerID != running.ContainerID {
+		t.Errorf("adopted task is %s in container %q, want %s in container %q",
+			adopted.State, adopted.ContainerID, task.Running, running.ContainerID)
+	}
+	if adopted.Name != running.Name || adopted.Env["PORT"] != "8080" || adopted.SpecHash() != running.SpecHash() {
+		t.Errorf("adopted task %+v was not rebuilt from its container", adopted)
+	}
+}
+
+// markRestarting moves the stored Task to Restarting, as RestartTask does before replacing its container.
+func markRestarting(t *testing.T, w *Worker, id uuid.UUID) *task.Task {
+	t.Helper()
+	restarting, err := store.Update(w.DB, id.String(), func(persisted *task.Task) (*task.Task, error) {
+		return persisted, persisted.Transition(task.Restarting, "restart requested")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return restarting
+}
+
+func TestStopRestartingTask(t *testing.T) {
+	w, _ := newTestWorker(t)
+	running := schedule(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx"})
+	restarting := markRestarting(t, w, running.ID)
+
+	stop := *restarting
+	stop.State = task.Complete
+	stop.Generation = restarting.Generation + 1
+	w.QueueTask(stop)
+	if res := w.runTask(); res.Error != nil {
+		t.Fatalf("failed to stop restarting task: %v", res.Error)
+	}
+	stopped := waitForState(t, w, running.ID, task.Complete)
+	if stopped.Generation != stop.Generation {
+		t.Errorf("stopped task has Generation %d, want %d", stopped.Generation, stop.Generation)
+	}
+}
+
+func TestStartTaskStoppedWhileStarting(t *testing.T) {
+	w, _ := newTestWorker(t)
+	running := schedule(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx"})
+	restarting := markRestarting(t, w, running.ID)
+
+	// The Task is stopped while its new container starts.
+	if _, err := store.Update(w.DB, running.ID.String(), func(persisted *task.Task) (*task.Task, error) {
+		return persisted, persisted.Transition(task.Stopping, "stop requested")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res := w.StartTask(*restarting)
+	if res.Error == nil {
+		t.Fatal("task started although it was stopped meanwhile")
+	}
+	if inspect := w.InspectTask(task.Task{ContainerID: res.ContainerID}); !errors.Is(inspect.Error, task.ErrContainerNotFound) {
+		t.Errorf("new container of stopped task still exists: %+v", inspect)
+	}
+	if stopping := get(t, w, running.ID); stopping.State != task.Stopping {
+		t.Errorf("task stopped while starting is %s, want %s", stopping.State, task.Stopping)
+	}
+}
+
+func TestRestartTaskAcknowledgesRejectedRequest(t *testing.T) {
+	w, _ := newTestWorker(t)
+	running := schedule(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx", Generation: 1})
+
+	// The Manager asks to restart the Task in a container it has already left.
+	stale := *running
+	stale.ContainerID = "gone"
+	stale.Generation = 2
+	if res := w.RestartTask(stale, "health check failed"); res.Error == nil {
+		t.Fatal("restarted task in a container it is no longer in")
+	}
+
+	acknowledged := get(t, w, running.ID)
+	if acknowledged.State != task.Running || acknowledged.Generation != 2 {
+		t.Errorf("task is %s with Generation %d, want %s with Generation 2",
+			acknowledged.State, acknowledged.Generation, task.Running)
+	}
+}
+
+func TestRestartTaskRefusesFailedTask(t *testing.T) {
+	w, rt := newTestWorker(t)
+	running := schedule(t, w, task.Task{ID: uuid.New(), Name: "web", Image: "nginx"})
+	if err := rt.Exit(running.ContainerID, 1); err != nil {
+		t.Fatal(err)
+	}
+	w.updateTasks()
+
+	// A restart requested before the Task failed arrives late.
+	if res := w.RestartTask(*running, "health check failed"); !errors.Is(res.Error, task.ErrInvalidTransition) {
+		t.Errorf("restarting a Failed task returned %v, want %v", res.Error, task.ErrInvalidTransition)
+	}
+	if failed := get(t, w, running.ID); failed.State != task.Failed || failed.RestartCount != 0 {
+		t.Errorf("task is %s with RestartCount %d, want %s without restarts", failed.State, failed.RestartCount, task.Failed)
+	}
+}